@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditRotateLayout timestamps the names of rotated audit logs
+const auditRotateLayout = "20060102T150405.000000000Z"
+
+// AuditRecord is one line of the audit log, written for every completion
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	ClientIP  string    `json:"clientIp"`
+	Endpoint  string    `json:"endpoint"`
+	Model     string    `json:"model"`
+	// Prompt, Suffix and System are what the model was sent once templates
+	// and defaults were applied. Chats record their Messages instead.
+	Prompt   string    `json:"prompt,omitempty"`
+	Suffix   string    `json:"suffix,omitempty"`
+	System   string    `json:"system,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+	Response string    `json:"response"`
+	// Choices holds every completion when the request set n above 1
+	Choices []string `json:"choices,omitempty"`
+	// Usage is omitted for streams, whose token counts aren't reported
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// AuditLogger appends AuditRecords to a JSONL file, kept apart from the
+// operational logs. Writes are buffered and flushed periodically by Run, and
+// the file is rotated once it would grow past maxBytes.
+type AuditLogger struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+}
+
+// NewAuditLogger opens, or creates, the audit log at path. A maxBytes of zero
+// never rotates it.
+func NewAuditLogger(path string, maxBytes int64) (*AuditLogger, error) {
+	a := &AuditLogger{path: path, maxBytes: maxBytes}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the audit log for appending. The caller must hold a.mu unless a
+// isn't shared yet.
+func (a *AuditLogger) open() error {
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file = file
+	a.buf = bufio.NewWriter(file)
+	a.size = info.Size()
+	return nil
+}
+
+// rotate moves the full audit log aside, named by the time it was rotated,
+// and starts a new one. The caller must hold a.mu.
+func (a *AuditLogger) rotate() error {
+	if err := a.buf.Flush(); err != nil {
+		return err
+	}
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(a.path, a.path+"."+time.Now().UTC().Format(auditRotateLayout)); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// Log appends rec to the audit log
+func (a *AuditLogger) Log(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxBytes > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.buf.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// Flush writes buffered records to the audit log
+func (a *AuditLogger) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.buf.Flush()
+}
+
+// Close flushes buffered records and closes the audit log
+func (a *AuditLogger) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.buf.Flush(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// Run flushes buffered records every interval until ctx is done
+func (a *AuditLogger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				slog.Warn("failed to flush audit log", "path", a.path, "error", err)
+			}
+		}
+	}
+}
+
+// record writes an audit record of a completion served for c, filling in the
+// request's details. A nil logger records nothing, and a failed write is only
+// logged so it never fails the request.
+func (a *AuditLogger) record(c *gin.Context, rec AuditRecord) {
+	if a == nil {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	rec.RequestID = c.GetString(requestIDContextKey)
+	rec.ClientIP = c.ClientIP()
+	rec.Endpoint = c.FullPath()
+	if err := a.Log(rec); err != nil {
+		slog.WarnContext(c.Request.Context(), "failed to write audit record", "path", a.path, "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLoggerRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := NewAuditLogger(path, 200)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+
+	for _, prompt := range []string{"first", "second", "third"} {
+		if err := logger.Log(AuditRecord{RequestID: prompt, Prompt: prompt, Response: "a response long enough to fill most of the file"}); err != nil {
+			t.Fatalf("failed to log: %v", err)
+		}
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil || len(rotated) == 0 {
+		t.Fatalf("expected a rotated audit log, got %v (%v)", rotated, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open current audit log: %v", err)
+	}
+	defer file.Close()
+
+	var last AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+	}
+	if last.Prompt != "third" {
+		t.Errorf("expected the latest record in the current log, got %+v", last)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoHealthyBackend is returned when every Ollama backend is marked down
+var ErrNoHealthyBackend = fmt.Errorf("%w: no healthy backend", ErrOllamaUnavailable)
+
+// backend is a single Ollama instance
+type backend struct {
+	url string
+
+	mu        sync.Mutex
+	healthy   bool
+	downUntil time.Time
+}
+
+// backendPool spreads requests across Ollama backends round-robin, skipping
+// backends that recently failed to connect
+type backendPool struct {
+	backends []*backend
+	next     atomic.Uint64
+	cooldown time.Duration
+}
+
+// validateOllamaURL checks an OLLAMA_URL entry is an absolute http or https
+// URL, so a typo fails at startup rather than on every request
+func validateOllamaURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host is missing")
+	}
+	return nil
+}
+
+// newBackendPool creates a pool with every backend initially healthy
+func newBackendPool(urls []string, cooldown time.Duration) *backendPool {
+	p := &backendPool{cooldown: cooldown}
+	for _, u := range urls {
+		p.backends = append(p.backends, &backend{url: u, healthy: true})
+	}
+	return p
+}
+
+// pick returns the next healthy backend in round-robin order
+func (p *backendPool) pick() (*backend, error) {
+	n := len(p.backends)
+	start := p.next.Add(1) - 1
+	for i := 0; i < n; i++ {
+		b := p.backends[(start+uint64(i))%uint64(n)]
+		if b.isHealthy() {
+			return b, nil
+		}
+	}
+	return nil, ErrNoHealthyBackend
+}
+
+// isHealthy reports whether the backend can take requests
+func (b *backend) isHealthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy
+}
+
+// markDown takes the backend out of rotation for at least cooldown
+func (p *backendPool) markDown(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.healthy {
+		slog.Warn("marking ollama backend unhealthy", "backend", b.url, "cooldown", p.cooldown.String())
+	}
+	b.healthy = false
+	b.downUntil = time.Now().Add(p.cooldown)
+}
+
+// markUp puts the backend back into rotation
+func (b *backend) markUp() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.healthy {
+		slog.Info("ollama backend recovered", "backend", b.url)
+	}
+	b.healthy = true
+}
+
+// dueForCheck reports whether a down backend's cooldown has passed
+func (b *backend) dueForCheck() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.healthy && time.Now().After(b.downUntil)
+}
+
+// MonitorBackends periodically pings backends marked down and returns them to
+// rotation once they answer. It runs until ctx is cancelled.
+func (s *LLMService) MonitorBackends(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, b := range s.backends.backends {
+			if !b.dueForCheck() {
+				continue
+			}
+			if err := s.pingBackend(ctx, b); err != nil {
+				s.backends.markDown(b)
+				continue
+			}
+			b.markUp()
+		}
+	}
+}
+
+// pingBackend checks a single backend directly, bypassing the pool
+func (s *LLMService) pingBackend(ctx context.Context, b *backend) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	s.setOllamaHeaders(ctx, req)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "strings"
+
+// normalizeBasePath turns BASE_PATH into the prefix every route is registered
+// under, with a leading slash and no trailing one. An empty or "/" base path
+// serves from the root.
+func normalizeBasePath(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "/")
+	if value == "" {
+		return ""
+	}
+	return "/" + value
+}
+
+// underBasePath prefixes each of paths with basePath, for the middleware that
+// match request paths themselves rather than routes
+func underBasePath(basePath string, paths ...string) []string {
+	prefixed := make([]string, len(paths))
+	for i, path := range paths {
+		prefixed[i] = basePath + path
+	}
+	return prefixed
+}
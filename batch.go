@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchPrompts caps how many prompts a single batch may carry
+const maxBatchPrompts = 100
+
+// BatchRequest is a set of independent prompts completed with the same model
+type BatchRequest struct {
+	Prompts []string `json:"prompts" binding:"required,min=1"`
+	Model   string   `json:"model"`
+	// MaxConcurrency caps how many prompts run at once, defaulting to and
+	// never exceeding the service's generation limit
+	MaxConcurrency int `json:"maxConcurrency"`
+}
+
+// BatchResult is the outcome of one prompt, at the same index as its prompt
+type BatchResult struct {
+	Index    int    `json:"index"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Usage    *Usage `json:"usage,omitempty"`
+}
+
+// BatchResponse lists every prompt's result in input order
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+	Model   string        `json:"model"`
+	Time    string        `json:"time"`
+}
+
+// RunBatch completes prompts with at most workers running at once. A failed
+// prompt only fails its own result, and prompts not yet started when ctx is
+// done are reported as cancelled.
+func (s *LLMService) RunBatch(ctx context.Context, prompts []string, model string, workers int) []BatchResult {
+	results := make([]BatchResult, len(prompts))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = s.runBatchItem(ctx, i, PromptRequest{Prompt: prompts[i], Model: model})
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < len(prompts); next++ {
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := next; i < len(prompts); i++ {
+		results[i] = BatchResult{Index: i, Error: wrapRequestError(ctx.Err()).Error()}
+	}
+	return results
+}
+
+// runBatchItem completes a single batch prompt
+func (s *LLMService) runBatchItem(ctx context.Context, index int, req PromptRequest) BatchResult {
+	startTime := time.Now()
+	result, err := s.GetCompletion(ctx, req)
+	if err != nil {
+		return BatchResult{Index: index, Error: err.Error(), Time: time.Since(startTime).String()}
+	}
+	usage := newUsage(result)
+	return BatchResult{
+		Index:    index,
+		Response: result.Response,
+		Time:     time.Since(startTime).String(),
+		Usage:    &usage,
+	}
+}
+
+// batchHandler serves POST /api/batch
+func batchHandler(llmService *LLMService, validator *ModelValidator, maxPromptBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		if len(req.Prompts) > maxBatchPrompts {
+			respondErrorCode(c, CodeBadRequest, "too many prompts", gin.H{"limit": maxBatchPrompts})
+			return
+		}
+		for _, prompt := range req.Prompts {
+			if !checkPromptSize(c, len(prompt), maxPromptBytes) {
+				return
+			}
+		}
+
+		if !validateModel(c, validator, req.Model) {
+			return
+		}
+
+		workers := llmService.limiter.Capacity()
+		if req.MaxConcurrency > 0 && req.MaxConcurrency < workers {
+			workers = req.MaxConcurrency
+		}
+
+		startTime := time.Now()
+		results := llmService.RunBatch(c.Request.Context(), req.Prompts, req.Model, workers)
+
+		c.JSON(http.StatusOK, BatchResponse{
+			Results: results,
+			Model:   req.Model,
+			Time:    time.Since(startTime).String(),
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on a single POST /api/benchmark
+const (
+	// defaultBenchmarkRuns is how many times the prompt runs when runs is unset
+	defaultBenchmarkRuns = 5
+	// maxBenchmarkRuns caps runs so one benchmark can't occupy the model for long
+	maxBenchmarkRuns = 100
+)
+
+// benchmarkPrompt is run when a benchmark doesn't supply its own, long enough
+// an answer to give a steady tokens per second figure
+const benchmarkPrompt = "Write a short paragraph explaining how a hash table works."
+
+// BenchmarkRequest runs one prompt repeatedly to measure a model's throughput
+type BenchmarkRequest struct {
+	// Prompt defaults to benchmarkPrompt
+	Prompt string `json:"prompt"`
+	Model  string `json:"model"`
+	// Runs defaults to defaultBenchmarkRuns
+	Runs int `json:"runs"`
+	// Concurrency is how many runs go at once. It defaults to 1 so runs don't
+	// contend with each other, and never exceeds the service's generation limit.
+	Concurrency int                `json:"concurrency"`
+	Options     *GenerationOptions `json:"options"`
+}
+
+// BenchmarkRun is the measurement of one run, at the same index as it ran
+type BenchmarkRun struct {
+	Index              int     `json:"index"`
+	EvalCount          int     `json:"evalCount,omitempty"`
+	EvalDurationMs     float64 `json:"evalDurationMs,omitempty"`
+	TokensPerSecond    float64 `json:"tokensPerSecond,omitempty"`
+	TimeToFirstTokenMs float64 `json:"timeToFirstTokenMs,omitempty"`
+	TotalDurationMs    float64 `json:"totalDurationMs,omitempty"`
+	Error              string  `json:"error,omitempty"`
+}
+
+// BenchmarkStats summarises a measurement across the successful runs
+type BenchmarkStats struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+}
+
+// BenchmarkResponse is the aggregate of every run along with each run's own
+// measurement
+type BenchmarkResponse struct {
+	Model       string `json:"model"`
+	Runs        int    `json:"runs"`
+	Concurrency int    `json:"concurrency"`
+	// Failed counts the runs left out of the stats because they errored
+	Failed                 int            `json:"failed"`
+	TokensPerSecond        BenchmarkStats `json:"tokensPerSecond"`
+	MeanTimeToFirstTokenMs float64        `json:"meanTimeToFirstTokenMs"`
+	// TotalDurationMs is the wall clock time of the whole benchmark
+	TotalDurationMs float64        `json:"totalDurationMs"`
+	Results         []BenchmarkRun `json:"results"`
+}
+
+// RunBenchmark streams req runs times with at most workers running at once. A
+// failed run only fails its own result.
+func (s *LLMService) RunBenchmark(ctx context.Context, req PromptRequest, runs int, workers int) []BenchmarkRun {
+	results := make([]BenchmarkRun, runs)
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = s.benchmarkRun(ctx, i, req)
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < runs; next++ {
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := next; i < runs; i++ {
+		results[i] = BenchmarkRun{Index: i, Error: wrapRequestError(ctx.Err()).Error()}
+	}
+	return results
+}
+
+// benchmarkRun streams a single run, timing the first token as it arrives and
+// taking eval_count and eval_duration from the final chunk
+func (s *LLMService) benchmarkRun(ctx context.Context, index int, req PromptRequest) BenchmarkRun {
+	result := BenchmarkRun{Index: index}
+	if err := s.checkModel(req.Model); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := s.limiter.Acquire(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+
+	ollamaReq := s.buildOllamaRequest(req, true)
+	defer observeOllamaRequest("generate", ollamaReq.Model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "generate", ollamaReq.Model)
+	defer span.End()
+
+	startTime := time.Now()
+	resp, err := s.post(ctx, "/api/generate", ollamaReq)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	body := watchIdle(resp.Body, s.streamIdleTimeout)
+	defer body.Close()
+
+	chunks := 0
+	err = readStream(ctx, body, func(line []byte) (bool, error) {
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return false, fmt.Errorf("failed to decode ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return false, fmt.Errorf("%w: %s", ErrGenerationFailed, chunk.Error)
+		}
+		chunks++
+		if chunk.Response != "" && result.TimeToFirstTokenMs == 0 {
+			result.TimeToFirstTokenMs = millis(time.Since(startTime))
+		}
+		if chunk.Done {
+			recordTokens(ctx, ollamaReq.Model, chunk.PromptEvalCount, chunk.EvalCount)
+			meterTokens(ctx, chunk.EvalCount)
+			s.observeLoad(chunk.LoadDuration)
+			result.EvalCount = chunk.EvalCount
+			result.EvalDurationMs = millis(time.Duration(chunk.EvalDuration))
+			if chunk.EvalDuration > 0 {
+				result.TokensPerSecond = float64(chunk.EvalCount) / time.Duration(chunk.EvalDuration).Seconds()
+			}
+		}
+		return chunk.Done, nil
+	})
+	result.TotalDurationMs = millis(time.Since(startTime))
+	if errors.Is(err, ErrStreamStalled) {
+		// Ollama never sent its counts, but it streams a token per chunk
+		recordTokens(ctx, ollamaReq.Model, 0, chunks)
+		meterTokens(ctx, chunks)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// summarizeBenchmark aggregates the successful runs into resp
+func summarizeBenchmark(resp *BenchmarkResponse) {
+	var tokensPerSecond, firstToken []float64
+	for _, run := range resp.Results {
+		if run.Error != "" {
+			resp.Failed++
+			continue
+		}
+		tokensPerSecond = append(tokensPerSecond, run.TokensPerSecond)
+		firstToken = append(firstToken, run.TimeToFirstTokenMs)
+	}
+	resp.TokensPerSecond = BenchmarkStats{
+		Mean: mean(tokensPerSecond),
+		P50:  percentile(tokensPerSecond, 50),
+		P95:  percentile(tokensPerSecond, 95),
+	}
+	resp.MeanTimeToFirstTokenMs = mean(firstToken)
+}
+
+// millis converts d to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// mean is the average of values, 0 when there are none
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// percentile is the nearest-rank pth percentile of values, 0 when there are
+// none
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// benchmarkHandler serves POST /api/benchmark, running a prompt repeatedly
+// against a model and reporting its throughput
+func benchmarkHandler(llmService *LLMService, validator *ModelValidator, maxPromptBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BenchmarkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		if req.Prompt == "" {
+			req.Prompt = benchmarkPrompt
+		}
+		if req.Runs == 0 {
+			req.Runs = defaultBenchmarkRuns
+		}
+		if req.Runs < 0 || req.Runs > maxBenchmarkRuns {
+			respondErrorCode(c, CodeBadRequest, "runs must be between 1 and the limit", gin.H{"limit": maxBenchmarkRuns})
+			return
+		}
+		if req.Concurrency < 0 {
+			respondErrorCode(c, CodeBadRequest, "concurrency must not be negative", nil)
+			return
+		}
+		if !checkPromptSize(c, len(req.Prompt), maxPromptBytes) {
+			return
+		}
+		if !validateModel(c, validator, req.Model) {
+			return
+		}
+
+		workers := min(max(req.Concurrency, 1), req.Runs, llmService.limiter.Capacity())
+
+		startTime := time.Now()
+		prompt := PromptRequest{Prompt: req.Prompt, Model: req.Model, Options: req.Options}
+		resp := BenchmarkResponse{
+			Model:       llmService.ResolveModel(req.Model),
+			Runs:        req.Runs,
+			Concurrency: workers,
+			Results:     llmService.RunBenchmark(c.Request.Context(), prompt, req.Runs, workers),
+		}
+		resp.TotalDurationMs = millis(time.Since(startTime))
+		summarizeBenchmark(&resp)
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
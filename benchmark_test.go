@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "Hello"})
+		json.NewEncoder(w).Encode(OllamaResponse{
+			Model:        "llama2",
+			Done:         true,
+			EvalCount:    50,
+			EvalDuration: int64(2 * time.Second),
+		})
+	})
+	go func() {
+		for range requests {
+		}
+	}()
+	svc := newTestService(t, server.URL)
+
+	var metered atomic.Int64
+	ctx := withTokenMeter(context.Background(), func(tokens int) { metered.Add(int64(tokens)) })
+	resp := BenchmarkResponse{Results: svc.RunBenchmark(ctx, PromptRequest{Prompt: "hi"}, 3, 2)}
+	summarizeBenchmark(&resp)
+
+	if len(resp.Results) != 3 || resp.Failed != 0 {
+		t.Fatalf("expected 3 successful runs, got %+v", resp.Results)
+	}
+	for i, run := range resp.Results {
+		if run.Index != i || run.EvalCount != 50 || run.TimeToFirstTokenMs <= 0 {
+			t.Errorf("unexpected run %d: %+v", i, run)
+		}
+	}
+	if resp.TokensPerSecond.Mean != 25 || resp.TokensPerSecond.P95 != 25 {
+		t.Errorf("expected 25 tokens per second, got %+v", resp.TokensPerSecond)
+	}
+	if metered.Load() != 150 {
+		t.Errorf("expected every run's tokens charged to the budget, got %d", metered.Load())
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+	if got := percentile(values, 50); got != 3 {
+		t.Errorf("expected p50 of 3, got %v", got)
+	}
+	if got := percentile(values, 95); got != 5 {
+		t.Errorf("expected p95 of 5, got %v", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("expected 0 for no values, got %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedHeaders are replaced before request headers are logged
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// errorReader returns err once the buffered body has been read, so a body
+// that failed to buffer still fails for the handler
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// bodyLogWriter keeps the first max bytes written to the response
+type bodyLogWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+	max  int
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	if room := w.max + 1 - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// bodyLoggingMiddleware logs JSON request and response bodies at debug level,
+// truncated to maxLen bytes, with credentials and image data redacted. The
+// request body is buffered and replaced so handlers can still read it.
+func bodyLoggingMiddleware(maxLen int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+			c.Next()
+			return
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"headers", redactHeaders(c.Request.Header),
+		}
+		if c.Request.Body != nil && !isMultipart(c.ContentType()) {
+			body, err := io.ReadAll(c.Request.Body)
+			var rest io.Reader = bytes.NewReader(body)
+			if err != nil {
+				rest = io.MultiReader(rest, errorReader{err})
+			}
+			c.Request.Body = io.NopCloser(rest)
+			attrs = append(attrs, "body", truncateBody(redactImages(body), maxLen))
+		} else if c.Request.Body != nil {
+			attrs = append(attrs, "content_type", c.ContentType())
+		}
+		slog.DebugContext(ctx, "request body", attrs...)
+
+		writer := &bodyLogWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}, max: maxLen}
+		c.Writer = writer
+		c.Next()
+
+		slog.DebugContext(ctx, "response body",
+			"status", c.Writer.Status(),
+			"body", truncateBody(writer.body.Bytes(), maxLen),
+		)
+	}
+}
+
+// isMultipart reports whether a content type is a multipart upload, which is
+// left unbuffered since it may carry large files
+func isMultipart(contentType string) bool {
+	return strings.HasPrefix(contentType, "multipart/")
+}
+
+// redactHeaders copies header with credential values replaced
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[REDACTED]")
+		}
+	}
+	return redacted
+}
+
+// redactImages replaces a top-level images array in a JSON body with a
+// placeholder so base64 blobs stay out of the logs
+func redactImages(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	raw, ok := fields["images"]
+	if !ok {
+		return body
+	}
+
+	var images []json.RawMessage
+	_ = json.Unmarshal(raw, &images)
+	fields["images"], _ = json.Marshal(fmt.Sprintf("[%d images redacted]", len(images)))
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// truncateBody returns body as a string of at most maxLen bytes
+func truncateBody(body []byte, maxLen int) string {
+	if len(body) <= maxLen {
+		return string(body)
+	}
+	return string(body[:maxLen]) + "...(truncated)"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// ErrCircuitOpen is returned without contacting Ollama while the circuit
+// breaker is open
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrOllamaUnavailable)
+
+// newCircuitBreaker opens after threshold consecutive failures and lets a
+// single probe through once cooldown has passed. A threshold of zero or less
+// disables the breaker.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *gobreaker.CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "ollama",
+		MaxRequests: 1,
+		Timeout:     cooldown,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(threshold)
+		},
+		IsSuccessful: isBreakerSuccess,
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			slog.Warn("circuit breaker state changed", "breaker", name, "from", from.String(), "to", to.String())
+		},
+	})
+}
+
+// isBreakerSuccess reports whether err says nothing about Ollama's health,
+// so client cancellations, rejected requests and models still loading don't
+// trip the breaker
+func isBreakerSuccess(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrModelLoading) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode < 500
+	}
+	return false
+}
+
+// BreakerState returns the circuit breaker state for health reporting
+func (s *LLMService) BreakerState() string {
+	if s.breaker == nil {
+		return "disabled"
+	}
+	return s.breaker.State().String()
+}
+
+// callBreaker runs call through the circuit breaker, fast-failing with
+// ErrCircuitOpen while it is open
+func (s *LLMService) callBreaker(call func() (*http.Response, error)) (*http.Response, error) {
+	if s.breaker == nil {
+		return call()
+	}
+	result, err := s.breaker.Execute(func() (interface{}, error) {
+		return call()
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return nil, ErrCircuitOpen
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.(*http.Response), nil
+}
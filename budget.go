@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// budgetDayLayout formats the UTC day a budget applies to
+const budgetDayLayout = "2006-01-02"
+
+// tokenMeterKey is the context.Context key holding a token meter
+type tokenMeterKey struct{}
+
+// withTokenMeter returns a context whose completions report the tokens they
+// generated to meter
+func withTokenMeter(ctx context.Context, meter func(tokens int)) context.Context {
+	return context.WithValue(ctx, tokenMeterKey{}, meter)
+}
+
+// meterTokens reports tokens generated on behalf of ctx to its meter, if any
+func meterTokens(ctx context.Context, tokens int) {
+	if meter, ok := ctx.Value(tokenMeterKey{}).(func(int)); ok && tokens > 0 {
+		meter(tokens)
+	}
+}
+
+// budgetFile is the on-disk form of a day's token usage
+type budgetFile struct {
+	Day  string           `json:"day"`
+	Used map[string]int64 `json:"used"`
+}
+
+// TokenBudget caps the tokens each API token may have generated per UTC day.
+// Usage lives in memory and, when a path is set, is flushed to a file so a
+// restart keeps the day's counts.
+type TokenBudget struct {
+	limit int64
+	path  string
+
+	mu    sync.Mutex
+	day   string
+	used  map[string]int64
+	dirty bool
+}
+
+// NewTokenBudget creates a budget of limit generated tokens per API token per
+// day. When path is set, usage already recorded there for today is loaded.
+func NewTokenBudget(limit int64, path string) (*TokenBudget, error) {
+	b := &TokenBudget{
+		limit: limit,
+		path:  path,
+		day:   time.Now().UTC().Format(budgetDayLayout),
+		used:  make(map[string]int64),
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved budgetFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid token budget file: %w", err)
+	}
+	if saved.Day == b.day && saved.Used != nil {
+		b.used = saved.Used
+	}
+	return b, nil
+}
+
+// rollover starts a fresh day once midnight UTC has passed. The caller must
+// hold b.mu.
+func (b *TokenBudget) rollover(now time.Time) {
+	if day := now.UTC().Format(budgetDayLayout); day != b.day {
+		b.day = day
+		b.used = make(map[string]int64)
+		b.dirty = true
+	}
+}
+
+// Remaining returns how many tokens id may still generate today
+func (b *TokenBudget) Remaining(id string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	return max(b.limit-b.used[id], 0)
+}
+
+// Charge records tokens generated for id
+func (b *TokenBudget) Charge(id string, tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	b.used[id] += int64(tokens)
+	b.dirty = true
+}
+
+// Flush writes today's usage to the budget file if it changed since the last
+// flush. The file is replaced atomically so a crash never leaves it half
+// written.
+func (b *TokenBudget) Flush() error {
+	if b.path == "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(budgetFile{Day: b.day, Used: b.used})
+	b.dirty = false
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
+
+// Run flushes usage every interval until ctx is done
+func (b *TokenBudget) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				slog.Warn("failed to flush token budget", "path", b.path, "error", err)
+			}
+		}
+	}
+}
+
+// nextBudgetReset returns the next midnight UTC after now
+func nextBudgetReset(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// tokenBudgetMiddleware rejects requests from API tokens that have used up
+// their daily budget with 429, and charges completions to the caller's token.
+// It relies on authMiddleware having identified the token.
+func tokenBudgetMiddleware(budget *TokenBudget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetString(tokenIDContextKey)
+		if id == "" {
+			c.Next()
+			return
+		}
+
+		remaining := budget.Remaining(id)
+		c.Header("X-Token-Budget-Remaining", strconv.FormatInt(remaining, 10))
+		if remaining == 0 {
+			resetAt := nextBudgetReset(time.Now())
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+			abortWithErrorCode(c, CodeBudgetExhausted, "daily token budget exhausted", gin.H{
+				"remaining": remaining,
+				"limit":     budget.limit,
+				"resetAt":   resetAt.Format(time.RFC3339),
+			})
+			return
+		}
+
+		ctx := withTokenMeter(c.Request.Context(), func(tokens int) {
+			budget.Charge(id, tokens)
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenBudgetChargesRaw(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "hi", Done: true, EvalCount: 7})
+	})
+	go func() {
+		for range requests {
+		}
+	}()
+	svc := newTestService(t, server.URL)
+	budget, err := NewTokenBudget(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(tokenIDContextKey, "client") })
+	router.Use(tokenBudgetMiddleware(budget))
+	router.POST("/api/raw", rawHandler(svc))
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/raw", strings.NewReader(`{"model":"llama2","prompt":"hi"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if remaining := budget.Remaining("client"); remaining != 3 {
+		t.Errorf("expected 3 tokens left after a raw generation of 7, got %d", remaining)
+	}
+	post()
+	if w := post(); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), string(CodeBudgetExhausted)) {
+		t.Errorf("expected the exhausted budget to block /api/raw with 429, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTokenBudgetChargesPartialOnTimeout(t *testing.T) {
+	server, _ := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		for range 5 {
+			json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "tok "})
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	svc := newTestService(t, server.URL)
+	svc.timeout = 100 * time.Millisecond
+
+	var metered atomic.Int64
+	ctx := withTokenMeter(context.Background(), func(tokens int) { metered.Add(int64(tokens)) })
+	resp, err := svc.GetCompletion(ctx, PromptRequest{Prompt: "hi", PartialOnTimeout: true})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if resp.Done || resp.EvalCount != 5 {
+		t.Errorf("expected a partial response counting 5 tokens, got done=%v evalCount=%d", resp.Done, resp.EvalCount)
+	}
+	if got := metered.Load(); got != 5 {
+		t.Errorf("expected the 5 streamed tokens charged, got %d", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// ResponseCache is an in-memory LRU cache of completions
+type ResponseCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key   string
+	value OllamaResponse
+}
+
+// NewResponseCache creates a cache holding at most maxEntries completions. A
+// size of zero disables caching.
+func NewResponseCache(maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached completion for key
+func (c *ResponseCache) Get(key string) (OllamaResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return OllamaResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Add stores a completion, evicting the least recently used entry when full
+func (c *ResponseCache) Add(key string, value OllamaResponse) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Flush removes every entry and returns how many were dropped
+func (c *ResponseCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.order.Len()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	return n
+}
+
+// cacheKey hashes everything sent to Ollama so only identical requests share an entry
+func cacheKey(req OllamaRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isCacheable reports whether a request is deterministic enough to cache,
+// which it is when temperature, after merging defaults, is 0 or unset
+func isCacheable(req OllamaRequest) bool {
+	return req.Options == nil || req.Options.Temperature == nil || *req.Options.Temperature == 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusCancelled is returned for a generation stopped through /api/cancel,
+// following nginx's "client closed request"
+const statusCancelled = 499
+
+// cancelEntry is one in-flight generation
+type cancelEntry struct {
+	cancel context.CancelFunc
+}
+
+// cancelRegistry maps in-flight request IDs to their cancel functions
+type cancelRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*cancelEntry
+}
+
+// newCancelRegistry creates an empty registry
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{entries: make(map[string]*cancelEntry)}
+}
+
+// register records cancel under id and returns the function that removes it
+// again once the generation finishes
+func (r *cancelRegistry) register(id string, cancel context.CancelFunc) func() {
+	entry := &cancelEntry{cancel: cancel}
+
+	r.mu.Lock()
+	r.entries[id] = entry
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// A newer request may have reused the ID, only remove our own entry
+		if r.entries[id] == entry {
+			delete(r.entries, id)
+		}
+	}
+}
+
+// cancel stops the generation registered under id and reports whether one was found
+func (r *cancelRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	entry, ok := r.entries[id]
+	delete(r.entries, id)
+	r.mu.Unlock()
+
+	if ok {
+		entry.cancel()
+	}
+	return ok
+}
+
+// cancelHandler serves POST /api/cancel/:id
+func cancelHandler(registry *cancelRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if !registry.cancel(id) {
+			respondErrorCode(c, CodeNotFound, "no generation in progress with that request ID", gin.H{"id": id})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "cancelled", "id": id})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Message is a single turn in a chat conversation
+type Message struct {
+	Role    string `json:"role" binding:"required"`
+	Content string `json:"content"`
+	// ToolCalls are the tools an assistant message asks to call
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolName is the tool whose result a tool message holds
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+// ChatRequest is our API's chat request structure
+type ChatRequest struct {
+	Messages []Message          `json:"messages" binding:"required,min=1,dive"`
+	Model    string             `json:"model"`
+	Options  *GenerationOptions `json:"options"`
+	// Truncate drops the oldest turns, keeping the system prompt, when the
+	// conversation would overflow the model's context window
+	Truncate bool `json:"truncate"`
+	// SessionID continues the conversation stored under it, which the
+	// request's messages and the reply are then added to
+	SessionID string `json:"sessionId" binding:"max=128"`
+	// Tools are functions the model may call. Those registered with the
+	// server are run by it, any others are returned for the client to run.
+	Tools []Tool `json:"tools" binding:"omitempty,dive"`
+}
+
+// ChatResponse is our API's chat response structure
+type ChatResponse struct {
+	Message Message `json:"message"`
+	Model   string  `json:"model"`
+	Time    string  `json:"time"`
+	// SessionID is the session the turn was stored under, if any
+	SessionID string `json:"sessionId,omitempty"`
+	// Truncated is set when older turns were dropped to fit the context
+	// window, DroppedMessages saying how many
+	Truncated       bool `json:"truncated,omitempty"`
+	DroppedMessages int  `json:"droppedMessages,omitempty"`
+	// Trace holds the tool calls and results exchanged before the reply,
+	// ending with it, when the server ran tools
+	Trace []Message `json:"trace,omitempty"`
+}
+
+// OllamaChatRequest represents the request structure for Ollama's chat API
+type OllamaChatRequest struct {
+	Model    string             `json:"model"`
+	Messages []Message          `json:"messages"`
+	Stream   bool               `json:"stream"`
+	Options  *GenerationOptions `json:"options,omitempty"`
+	Tools    []Tool             `json:"tools,omitempty"`
+}
+
+// OllamaChatResponse represents the response from Ollama's chat API
+type OllamaChatResponse struct {
+	Model     string  `json:"model"`
+	CreatedAt string  `json:"created_at"`
+	Message   Message `json:"message"`
+	Done      bool    `json:"done"`
+	// Error is set instead of done when generation fails part way through
+	Error string `json:"error"`
+
+	// Set on the final response only
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+// withDefaultSystem starts a conversation with DEFAULT_SYSTEM_PROMPT unless it
+// has a system message of its own
+func (s *LLMService) withDefaultSystem(messages []Message) []Message {
+	if s.defaultSystem == "" || slices.ContainsFunc(messages, func(m Message) bool { return m.Role == "system" }) {
+		return messages
+	}
+	return append([]Message{{Role: "system", Content: s.defaultSystem}}, messages...)
+}
+
+// GetChatCompletion sends a conversation to Ollama and returns its final
+// response holding the assistant's reply
+func (s *LLMService) GetChatCompletion(ctx context.Context, req ChatRequest) (*OllamaChatResponse, error) {
+	if err := s.checkModel(req.Model); err != nil {
+		return nil, err
+	}
+	if err := s.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+
+	model := s.ResolveModel(req.Model)
+	defer observeOllamaRequest("chat", model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "chat", model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/chat", OllamaChatRequest{
+		Model:    model,
+		Messages: s.withDefaultSystem(s.normalizeMessages(req.Messages)),
+		Options:  s.resolveOptions(model, req.Options),
+		Tools:    req.Tools,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp OllamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		if ctx.Err() != nil {
+			return nil, wrapRequestError(ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to decode ollama chat response: %w", err)
+	}
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrGenerationFailed, chatResp.Error)
+	}
+
+	recordTokens(ctx, model, chatResp.PromptEvalCount, chatResp.EvalCount)
+	meterTokens(ctx, chatResp.EvalCount)
+	return &chatResp, nil
+}
+
+// GetChatCompletionStream sends a conversation to Ollama in stream mode and
+// invokes onToken for every partial assistant message. Returning an error
+// from onToken stops the stream and that error is returned to the caller.
+func (s *LLMService) GetChatCompletionStream(ctx context.Context, req ChatRequest, onToken func(string) error) error {
+	if err := s.checkModel(req.Model); err != nil {
+		return err
+	}
+	if err := s.limiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+
+	model := s.ResolveModel(req.Model)
+	defer observeOllamaRequest("chat", model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "chat", model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/chat", OllamaChatRequest{
+		Model:    model,
+		Messages: s.withDefaultSystem(s.normalizeMessages(req.Messages)),
+		Stream:   true,
+		Options:  s.resolveOptions(model, req.Options),
+	})
+	if err != nil {
+		return err
+	}
+	body := watchIdle(resp.Body, s.streamIdleTimeout)
+	defer body.Close()
+
+	return readStream(ctx, body, func(line []byte) (bool, error) {
+		var chunk OllamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return false, fmt.Errorf("failed to decode ollama chat stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return false, fmt.Errorf("%w: %s", ErrGenerationFailed, chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			if err := onToken(chunk.Message.Content); err != nil {
+				return false, err
+			}
+		}
+
+		if chunk.Done {
+			recordTokens(ctx, model, chunk.PromptEvalCount, chunk.EvalCount)
+			meterTokens(ctx, chunk.EvalCount)
+		}
+		return chunk.Done, nil
+	})
+}
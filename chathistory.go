@@ -0,0 +1,72 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// ollamaDefaultNumCtx is the context window Ollama uses when a request
+// doesn't set num_ctx
+const ollamaDefaultNumCtx = 2048
+
+// checkChatLength rejects a conversation of more than limit messages with
+// 400. It reports whether the request may proceed.
+func checkChatLength(c *gin.Context, messages []Message, limit int) bool {
+	if limit > 0 && len(messages) > limit {
+		respondErrorCode(c, CodeBadRequest, "too many messages", gin.H{
+			"limit": limit,
+			"count": len(messages),
+		})
+		return false
+	}
+	return true
+}
+
+// contextWindow returns how many tokens of the model's context window a chat
+// with opts may fill, leaving room for num_predict tokens of reply when set
+func (s *LLMService) contextWindow(model string, opts *GenerationOptions) int {
+	resolved := s.resolveOptions(s.ResolveModel(model), opts)
+	window := ollamaDefaultNumCtx
+	if resolved != nil && resolved.NumCtx != nil && *resolved.NumCtx > 0 {
+		window = *resolved.NumCtx
+	}
+	if resolved != nil && resolved.NumPredict != nil && *resolved.NumPredict > 0 {
+		window -= *resolved.NumPredict
+	}
+	return window
+}
+
+// trimChatHistory drops the oldest turns of a conversation until it fits in
+// budget estimated tokens, keeping the leading system messages and the latest
+// message. It returns the messages to send, how many were dropped, and false
+// when even the system messages and the latest message don't fit.
+func trimChatHistory(messages []Message, budget int, charsPerToken float64) ([]Message, int, bool) {
+	system := 0
+	for system < len(messages) && messages[system].Role == "system" {
+		system++
+	}
+
+	used := 0
+	for _, m := range messages[:system] {
+		used += estimateTokens(m.Content, charsPerToken)
+	}
+
+	// Walk back from the latest message, keeping turns while they fit
+	start := len(messages)
+	for start > system {
+		tokens := estimateTokens(messages[start-1].Content, charsPerToken)
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start--
+	}
+	if used > budget || (start == len(messages) && system < len(messages)) {
+		return nil, 0, false
+	}
+	if start == system {
+		return messages, 0, true
+	}
+
+	kept := make([]Message, 0, system+len(messages)-start)
+	kept = append(kept, messages[:system]...)
+	kept = append(kept, messages[start:]...)
+	return kept, start - system, true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrimChatHistory(t *testing.T) {
+	system := Message{Role: "system", Content: "be brief"}        // 2 tokens
+	old := Message{Role: "user", Content: "aaaaaaaaaaaaaaaaaaaa"} // 5 tokens
+	reply := Message{Role: "assistant", Content: "bbbb"}          // 1 token
+	latest := Message{Role: "user", Content: "cccccccc"}          // 2 tokens
+
+	tests := []struct {
+		name     string
+		messages []Message
+		budget   int
+		want     []Message
+		dropped  int
+		ok       bool
+	}{
+		{
+			name:     "fits",
+			messages: []Message{system, old, reply, latest},
+			budget:   10,
+			want:     []Message{system, old, reply, latest},
+			ok:       true,
+		},
+		{
+			name:     "drops oldest turns and keeps system prompt",
+			messages: []Message{system, old, reply, latest},
+			budget:   5,
+			want:     []Message{system, reply, latest},
+			dropped:  1,
+			ok:       true,
+		},
+		{
+			name:     "keeps only the latest message",
+			messages: []Message{system, old, reply, latest},
+			budget:   4,
+			want:     []Message{system, latest},
+			dropped:  2,
+			ok:       true,
+		},
+		{
+			name:     "latest message alone overflows",
+			messages: []Message{system, old},
+			budget:   6,
+			ok:       false,
+		},
+		{
+			name:     "only system messages",
+			messages: []Message{system},
+			budget:   2,
+			want:     []Message{system},
+			ok:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped, ok := trimChatHistory(tt.messages, tt.budget, 4)
+			if ok != tt.ok {
+				t.Fatalf("expected ok=%v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if dropped != tt.dropped || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v with %d dropped, got %v with %d dropped", tt.want, tt.dropped, got, dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// maxChoices caps how many completions a single request may ask for with n
+const maxChoices = 8
+
+// ErrChoicesNeedTemperature is returned when several completions are asked
+// for at temperature 0, where every one would come out the same
+var ErrChoicesNeedTemperature = errors.New("n greater than 1 requires a non-zero temperature")
+
+// Choice is one of several completions generated for a request with n > 1
+type Choice struct {
+	Index             int            `json:"index"`
+	Response          string         `json:"response"`
+	Truncated         bool           `json:"truncated,omitempty"`
+	TruncatedByServer bool           `json:"truncatedByServer,omitempty"`
+	Stalled           bool           `json:"stalled,omitempty"`
+	FinishReason      string         `json:"finishReason,omitempty"`
+	Thinking          string         `json:"thinking,omitempty"`
+	Logprobs          []TokenLogprob `json:"logprobs,omitempty"`
+	Usage             Usage          `json:"usage"`
+}
+
+// validateChoices checks the request's n against the cap and the resolved
+// temperature, since identical samples are of no use
+func validateChoices(req PromptRequest, ollamaReq OllamaRequest) error {
+	if req.N < 0 || req.N > maxChoices {
+		return fmt.Errorf("n must be between 1 and %d", maxChoices)
+	}
+	if req.N > 1 && ollamaReq.Options != nil && ollamaReq.Options.Temperature != nil && *ollamaReq.Options.Temperature == 0 {
+		return ErrChoicesNeedTemperature
+	}
+	return nil
+}
+
+// GetCompletions generates n completions for req concurrently. Each one takes
+// its own slot from the limiter, so a fan-out never runs more generations
+// than the service allows. The first failure cancels the rest.
+func (s *LLMService) GetCompletions(ctx context.Context, req PromptRequest, n int) ([]*OllamaResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*OllamaResponse, n)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.GetCompletion(ctx, req)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// combinedUsage adds up the token counts and eval time of several completions
+func combinedUsage(results []*OllamaResponse) Usage {
+	var total OllamaResponse
+	for _, result := range results {
+		total.PromptEvalCount += result.PromptEvalCount
+		total.EvalCount += result.EvalCount
+		total.EvalDuration += result.EvalDuration
+	}
+	return newUsage(&total)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// completionHandler serves the prompt completion endpoints
+type completionHandler struct {
+	llm            *LLMService
+	validator      *ModelValidator
+	cache          *ResponseCache
+	templates      *PromptTemplates
+	thinking       *ThinkingStripper
+	cancels        *cancelRegistry
+	maxPromptBytes int
+	charsPerToken  float64
+	timeout        adaptiveTimeout
+	inFlight       completionGroup
+	summarize      summarizeConfig
+	auditLog       *AuditLogger
+}
+
+// handleJSON serves POST /api/complete
+func (h *completionHandler) handleJSON(c *gin.Context) {
+	var req PromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	h.complete(c, req)
+}
+
+// handleMultipart serves POST /api/complete/multipart, pairing the prompt
+// form fields with uploaded image files
+func (h *completionHandler) handleMultipart(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	req := PromptRequest{
+		Prompt: c.PostForm("prompt"),
+		Model:  c.PostForm("model"),
+		System: c.PostForm("system"),
+	}
+	if req.Prompt == "" {
+		respondErrorCode(c, CodeBadRequest, "prompt is required", nil)
+		return
+	}
+
+	images, err := encodeImageFiles(form.File["images"])
+	if err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+	req.Images = images
+
+	h.complete(c, req)
+}
+
+// handleTemplate serves POST /api/complete/template, completing a named
+// prompt template rendered with the request's variables
+func (h *completionHandler) handleTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	prompt, err := h.templates.Render(req.Template, req.Vars)
+	if err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	h.complete(c, PromptRequest{Prompt: prompt, Model: req.Model, Options: req.Options})
+}
+
+// complete validates req, runs it against Ollama, or the cache, and writes
+// the response
+func (h *completionHandler) complete(c *gin.Context, req PromptRequest) {
+	c.Set(modelContextKey, h.llm.ResolveModel(req.Model))
+	c.Set(promptLengthContextKey, len(req.Prompt)+len(req.Suffix))
+
+	if !checkPromptSize(c, len(req.Prompt)+len(req.Suffix), h.maxPromptBytes) {
+		return
+	}
+
+	if _, err := negotiateResponseVersion(c); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := validateFormat(req.Format); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := validateImages(req.Images); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := validateRaw(req); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := validateTranslate(req); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	ollamaReq := h.llm.buildOllamaRequest(req, false)
+	if !req.AutoSummarize && !checkContextWindow(c, ollamaReq, h.charsPerToken) {
+		return
+	}
+
+	if err := validateLogprobs(req); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := validateChoices(req, ollamaReq); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	// With a fallback available a missing model is left to fall back
+	if fallback := h.llm.FallbackModel(req); fallback != "" {
+		if !validateModel(c, h.validator, fallback) {
+			return
+		}
+	} else if !validateModel(c, h.validator, req.Model) {
+		return
+	}
+
+	if isDryRun(c) {
+		c.JSON(http.StatusOK, ollamaReq)
+		return
+	}
+
+	startTime := time.Now()
+	if req.AutoSummarize {
+		var ok bool
+		if req, ok = h.summarizeToFit(c, req); !ok {
+			return
+		}
+		ollamaReq = h.llm.buildOllamaRequest(req, false)
+	}
+
+	if req.N > 1 {
+		h.completeChoices(c, req, ollamaReq, startTime)
+		return
+	}
+
+	key := ""
+	if isCacheable(ollamaReq) {
+		key = cacheKey(ollamaReq)
+		if cached, ok := h.cache.Get(key); ok {
+			c.Header("X-Cache", "HIT")
+			if !h.translateResponse(c, c.Request.Context(), req, &cached) {
+				return
+			}
+			h.respond(c, req, ollamaReq, &cached, startTime)
+			return
+		}
+	}
+	c.Header("X-Cache", "MISS")
+
+	ctx, done := h.startGeneration(c, ollamaReq)
+	defer done()
+
+	var result *OllamaResponse
+	var err error
+	if isDeterministic(ollamaReq) {
+		// Identical deterministic requests in flight share one generation
+		result, err = h.inFlight.do(ctx, dedupeKey(req, ollamaReq), func(ctx context.Context) (*OllamaResponse, error) {
+			return h.llm.GetCompletion(ctx, req)
+		})
+	} else {
+		result, err = h.llm.GetCompletion(ctx, req)
+	}
+	if err != nil {
+		h.respondGenerationError(c, ctx, err)
+		return
+	}
+
+	if response, _ := h.finalResponse(req, result); hasFormat(req.Format) && !json.Valid([]byte(response)) {
+		respondErrorCode(c, CodeInvalidModelResponse, ErrInvalidJSONResponse.Error(), gin.H{"response": response})
+		return
+	}
+
+	// Neither a fallback response nor a truncated one is cached under the
+	// primary model's key
+	if key != "" && result.Done && result.Model == ollamaReq.Model {
+		h.cache.Add(key, *result)
+	}
+
+	if !h.translateResponse(c, ctx, req, result) {
+		return
+	}
+	h.respond(c, req, ollamaReq, result, startTime)
+}
+
+// summarizeToFit replaces the prompt of req with a summary when it is too
+// long for the model's context window. It reports whether the request may
+// proceed, having written an error response otherwise.
+func (h *completionHandler) summarizeToFit(c *gin.Context, req PromptRequest) (PromptRequest, bool) {
+	opts := req.Options
+	if req.NumCtx != nil {
+		opts = mergeOptions(opts, &GenerationOptions{NumCtx: req.NumCtx})
+	}
+	budget := h.llm.contextWindow(req.Model, opts) - estimateTokens(h.llm.systemPrompt(req), h.charsPerToken) - len(req.Context)
+	if estimateTokens(req.Prompt, h.charsPerToken) <= budget {
+		return req, true
+	}
+
+	summary, steps, err := h.llm.SummarizeToFit(c.Request.Context(), req, req.Prompt, budget, h.summarize)
+	if err != nil {
+		respondError(c, err)
+		return req, false
+	}
+	slog.InfoContext(c.Request.Context(), "summarized oversized prompt", "levels", steps.Levels, "chunks", steps.Chunks, "original_tokens", steps.OriginalTokens, "final_tokens", steps.FinalTokens)
+	c.Set(summarizationContextKey, &steps)
+	req.Prompt = summary
+	return req, true
+}
+
+// startGeneration returns the context to generate under and a func to call
+// once the generation is over. POST /api/cancel/:id can cancel it using the
+// X-Request-ID the request is answered with, and it is bounded by the
+// request's adaptive deadline, if any.
+func (h *completionHandler) startGeneration(c *gin.Context, ollamaReq OllamaRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	unregister := h.cancels.register(c.GetString(requestIDContextKey), cancel)
+
+	ctx, cancelDeadline, timeout := h.timeout.withDeadline(ctx, ollamaReq)
+	if timeout > 0 {
+		c.Set(timeoutContextKey, timeout)
+	}
+	return ctx, func() {
+		cancelDeadline()
+		unregister()
+		cancel()
+	}
+}
+
+// respondGenerationError writes the response for a generation under ctx,
+// from startGeneration, that failed. A cancellation through
+// POST /api/cancel/:id and a missed adaptive deadline are told apart from
+// other errors.
+func (h *completionHandler) respondGenerationError(c *gin.Context, ctx context.Context, err error) {
+	if errors.Is(ctx.Err(), context.Canceled) && c.Request.Context().Err() == nil {
+		respondErrorCode(c, CodeCancelled, "generation cancelled", nil)
+		return
+	}
+	if timeout := c.GetDuration(timeoutContextKey); timeout > 0 && errors.Is(err, ErrTimeout) {
+		respondErrorCode(c, CodeTimeout, err.Error(), gin.H{"timeout": timeout.String()})
+		return
+	}
+	respondError(c, err)
+}
+
+// completeChoices generates req.N completions side by side and writes them
+// as the response's choices. Samples are never cached.
+func (h *completionHandler) completeChoices(c *gin.Context, req PromptRequest, ollamaReq OllamaRequest, startTime time.Time) {
+	ctx, done := h.startGeneration(c, ollamaReq)
+	defer done()
+
+	results, err := h.llm.GetCompletions(ctx, req, req.N)
+	if err != nil {
+		h.respondGenerationError(c, ctx, err)
+		return
+	}
+
+	choices := make([]Choice, len(results))
+	for i, result := range results {
+		response, thinking := h.finalResponse(req, result)
+		if hasFormat(req.Format) && !json.Valid([]byte(response)) {
+			respondErrorCode(c, CodeInvalidModelResponse, ErrInvalidJSONResponse.Error(), gin.H{"response": response})
+			return
+		}
+		choices[i] = Choice{
+			Index:             i,
+			Response:          response,
+			Truncated:         !result.Done,
+			Logprobs:          newTokenLogprobs(result.Logprobs),
+			Usage:             newUsage(result),
+			TruncatedByServer: result.TruncatedByServer,
+			Stalled:           result.Stalled,
+			FinishReason:      finishReason(result),
+		}
+		if c.Query("includeThinking") == "true" {
+			choices[i].Thinking = thinking
+		}
+	}
+
+	resp := h.newResponse(c, req, ollamaReq, results[0], startTime)
+	resp.Usage = combinedUsage(results)
+	resp.Choices = choices
+	texts := make([]string, len(choices))
+	for i, choice := range choices {
+		texts[i] = choice.Response
+	}
+	h.auditLog.record(c, AuditRecord{
+		Model:    resp.ServedBy,
+		Prompt:   ollamaReq.Prompt,
+		Suffix:   ollamaReq.Suffix,
+		System:   ollamaReq.System,
+		Response: resp.Response,
+		Choices:  texts,
+		Usage:    &resp.Usage,
+	})
+	writeResponse(c, resp, time.Since(startTime))
+}
+
+// respond writes the completion response for result
+func (h *completionHandler) respond(c *gin.Context, req PromptRequest, ollamaReq OllamaRequest, result *OllamaResponse, startTime time.Time) {
+	resp := h.newResponse(c, req, ollamaReq, result, startTime)
+	h.auditLog.record(c, AuditRecord{
+		Model:    resp.ServedBy,
+		Prompt:   ollamaReq.Prompt,
+		Suffix:   ollamaReq.Suffix,
+		System:   ollamaReq.System,
+		Response: resp.Response,
+		Usage:    &resp.Usage,
+	})
+	writeResponse(c, resp, time.Since(startTime))
+}
+
+// newResponse builds the completion response for result
+func (h *completionHandler) newResponse(c *gin.Context, req PromptRequest, ollamaReq OllamaRequest, result *OllamaResponse, startTime time.Time) PromptResponse {
+	response, thinking := h.finalResponse(req, result)
+	resp := PromptResponse{
+		Response:          response,
+		Model:             req.Model,
+		Time:              time.Since(startTime).String(),
+		Usage:             newUsage(result),
+		ServedBy:          result.Model,
+		Truncated:         !result.Done,
+		Context:           result.Context,
+		Logprobs:          newTokenLogprobs(result.Logprobs),
+		TruncatedByServer: result.TruncatedByServer,
+		Stalled:           result.Stalled,
+		FinishReason:      finishReason(result),
+	}
+	if c.Query("includeThinking") == "true" {
+		resp.Thinking = thinking
+	}
+	if steps, ok := c.Value(summarizationContextKey).(*SummarizationSteps); ok {
+		resp.Summarization = steps
+	}
+	if translation, ok := c.Value(translationContextKey).(*Translation); ok {
+		resp.Response = translation.text
+		resp.Translation = translation
+	}
+	if c.Query("includePrompt") == "true" {
+		resp.ResolvedPrompt = &ResolvedPrompt{
+			Prompt:        ollamaReq.Prompt,
+			Suffix:        ollamaReq.Suffix,
+			System:        ollamaReq.System,
+			DefaultSystem: req.System == "" && !req.Raw && h.llm.defaultSystem != "",
+			Options:       ollamaReq.Options,
+		}
+	}
+	if isDebug(c) {
+		resp.Timings = newTimings(result)
+		if timeout := c.GetDuration(timeoutContextKey); timeout > 0 {
+			resp.Timeout = timeout.String()
+		}
+	}
+	return resp
+}
+
+// finalResponse returns the response text to send back and the reasoning
+// stripped from it, if the request asked for that
+func (h *completionHandler) finalResponse(req PromptRequest, result *OllamaResponse) (response string, thinking string) {
+	if !req.StripThinking {
+		return result.Response, ""
+	}
+	return h.thinking.Strip(result.Response)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the value of the environment variable key, or def when unset
+func envString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits the comma-separated environment variable key into its
+// trimmed, non-empty parts, or returns def when none are set
+func envList(key string, def []string) []string {
+	var list []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// envDuration parses the environment variable key as a positive Go duration,
+// logging a warning and returning def when it is invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Warn("invalid duration, using default", "key", key, "value", v, "default", def.String())
+		return def
+	}
+	return d
+}
+
+// envDurationOrZero parses the environment variable key like envDuration,
+// but also accepts an explicit zero for settings where zero turns them off
+func envDurationOrZero(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d == 0 {
+			return 0
+		}
+	}
+	return envDuration(key, def)
+}
+
+// envInt parses the environment variable key as a non-negative integer,
+// logging a warning and returning def when it is invalid
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("invalid integer, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envFloat parses the environment variable key as a non-negative number,
+// logging a warning and returning def when it is invalid
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		slog.Warn("invalid number, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+// envBool parses the environment variable key as a boolean, logging a
+// warning and returning def when it is invalid
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid boolean, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
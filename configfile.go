@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileConfig is the structure of the JSON file named by CONFIG_FILE
+type FileConfig struct {
+	// DefaultOptions are merged into every request
+	DefaultOptions *GenerationOptions `json:"defaultOptions"`
+	// ModelOptions are merged into requests for the named model, taking
+	// precedence over DefaultOptions. Request options win over both.
+	ModelOptions map[string]*GenerationOptions `json:"modelOptions"`
+	// Transforms are run on every prompt and response, in the order listed
+	Transforms *TransformsConfig `json:"transforms"`
+
+	// transforms are the pipelines built from Transforms
+	transforms Transforms
+}
+
+// OptionDefaults returns the defaults the config file sets
+func (cfg *FileConfig) OptionDefaults() OptionDefaults {
+	return OptionDefaults{Global: cfg.DefaultOptions, Models: cfg.ModelOptions}
+}
+
+// TransformPipelines returns the transforms the config file lists
+func (cfg *FileConfig) TransformPipelines() Transforms {
+	return cfg.transforms
+}
+
+// LoadConfigFile reads and validates the config file at path. Unknown fields
+// are rejected so typos don't silently go unapplied.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg FileConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := cfg.DefaultOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid defaultOptions in %s: %w", path, err)
+	}
+	for model, opts := range cfg.ModelOptions {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid modelOptions for %s in %s: %w", model, path, err)
+		}
+	}
+	if cfg.transforms, err = cfg.Transforms.build(); err != nil {
+		return nil, fmt.Errorf("invalid transforms in %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// WatchConfigFile reloads the config file whenever it changes and passes the
+// new config to apply. A file that fails to load is logged and the previous
+// config stays in effect. It runs until ctx is cancelled.
+func WatchConfigFile(ctx context.Context, path string, apply func(*FileConfig)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	// Watch the directory rather than the file, editors and config maps
+	// replace the file instead of writing it in place
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config file: %w", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		name := filepath.Clean(path)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+
+				cfg, err := LoadConfigFile(path)
+				if err != nil {
+					slog.Error("failed to reload config file, keeping previous config", "path", path, "error", err)
+					continue
+				}
+				apply(cfg)
+				slog.Info("reloaded config file", "path", path)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("config watcher error", "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// continuePrompt asks the model to carry on from a context array, which
+// Ollama needs a prompt alongside
+const continuePrompt = "Continue exactly where you stopped, without repeating anything you already wrote."
+
+// ContinueRequest extends a response that stopped early, typically with
+// finishReason length. Either Context is sent, or Response along with the
+// Prompt or Messages it answered.
+type ContinueRequest struct {
+	// Context is the array returned with the response being continued
+	Context []int `json:"context"`
+	// Response is the text generated so far. Without Context it is sent as
+	// the assistant's unfinished reply for the model to extend, with Context
+	// it only prefixes the continuation in text.
+	Response string `json:"response"`
+	// Prompt or Messages is what Response answered, when continuing without
+	// Context
+	Prompt   string             `json:"prompt"`
+	Messages []Message          `json:"messages" binding:"omitempty,dive"`
+	Model    string             `json:"model"`
+	Options  *GenerationOptions `json:"options"`
+}
+
+// validate checks the request carries one way of continuing
+func (req ContinueRequest) validate() error {
+	switch {
+	case len(req.Context) > 0 && (req.Prompt != "" || len(req.Messages) > 0):
+		return errors.New("context can't be combined with prompt or messages")
+	case len(req.Context) > 0:
+		return nil
+	case req.Response == "":
+		return errors.New("context or response is required")
+	case req.Prompt == "" && len(req.Messages) == 0:
+		return errors.New("prompt or messages is required to continue a response without context")
+	case req.Prompt != "" && len(req.Messages) > 0:
+		return errors.New("prompt and messages can't be combined")
+	}
+	return nil
+}
+
+// conversation is the chat the response is continued as, ending with the
+// unfinished assistant reply
+func (req ContinueRequest) conversation() []Message {
+	messages := req.Messages
+	if req.Prompt != "" {
+		messages = []Message{{Role: "user", Content: req.Prompt}}
+	}
+	return append(messages[:len(messages):len(messages)], Message{Role: "assistant", Content: req.Response})
+}
+
+// ContinueResponse is the continuation, along with everything needed to
+// continue it again
+type ContinueResponse struct {
+	// Continuation is only the newly generated text, Text the response so
+	// far with it appended
+	Continuation string `json:"continuation"`
+	Text         string `json:"text"`
+	Model        string `json:"model"`
+	Time         string `json:"time"`
+	Usage        Usage  `json:"usage"`
+	FinishReason string `json:"finishReason,omitempty"`
+	// Context is returned when continuing from a context, Messages, ending
+	// with the combined assistant reply, when continuing from the text
+	Context  []int     `json:"context,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// continueHandler serves POST /api/continue. A context array is continued by
+// generating from it again, while text is continued on the chat API, where a
+// conversation ending with an assistant message has the model extend that
+// message rather than start a new one.
+func continueHandler(llmService *LLMService, validator *ModelValidator, auditLog *AuditLogger, maxPromptBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ContinueRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		if err := req.validate(); err != nil {
+			respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+			return
+		}
+
+		size := len(req.Response) + len(req.Prompt) + chatContentSize(req.Messages)
+		c.Set(promptLengthContextKey, size)
+		if !checkPromptSize(c, size, maxPromptBytes) {
+			return
+		}
+
+		if !validateModel(c, validator, req.Model) {
+			return
+		}
+
+		startTime := time.Now()
+		resp := ContinueResponse{Model: req.Model}
+		var record AuditRecord
+		if len(req.Context) > 0 {
+			result, err := llmService.GetCompletion(c.Request.Context(), PromptRequest{
+				Prompt:         continuePrompt,
+				Model:          req.Model,
+				Options:        req.Options,
+				Context:        req.Context,
+				SkipPromptWrap: true,
+			})
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			resp.Continuation = result.Response
+			resp.Usage = newUsage(result)
+			resp.FinishReason = finishReason(result)
+			resp.Context = result.Context
+			record = AuditRecord{Model: result.Model, Prompt: continuePrompt}
+		} else {
+			messages := req.conversation()
+			result, err := llmService.GetChatCompletion(c.Request.Context(), ChatRequest{
+				Messages: messages,
+				Model:    req.Model,
+				Options:  req.Options,
+			})
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			resp.Continuation = result.Message.Content
+			resp.Usage = Usage{PromptTokens: result.PromptEvalCount, CompletionTokens: result.EvalCount}
+			resp.FinishReason = doneReasonFinish(result.DoneReason)
+			messages[len(messages)-1].Content += result.Message.Content
+			resp.Messages = messages
+			record = AuditRecord{Model: result.Model, Messages: req.conversation()}
+		}
+		resp.Text = req.Response + resp.Continuation
+		resp.Time = time.Since(startTime).String()
+
+		record.Response = resp.Continuation
+		record.Usage = &resp.Usage
+		auditLog.record(c, record)
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
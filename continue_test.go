@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestContinueRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ContinueRequest
+		wantErr bool
+	}{
+		{"context", ContinueRequest{Context: []int{1, 2}}, false},
+		{"text with prompt", ContinueRequest{Prompt: "tell me", Response: "Once"}, false},
+		{"text with messages", ContinueRequest{Messages: []Message{{Role: "user", Content: "hi"}}, Response: "Hel"}, false},
+		{"nothing", ContinueRequest{}, true},
+		{"text alone", ContinueRequest{Response: "Once"}, true},
+		{"context with prompt", ContinueRequest{Context: []int{1}, Prompt: "tell me"}, true},
+		{"prompt with messages", ContinueRequest{Prompt: "a", Messages: []Message{{Role: "user"}}, Response: "b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestContinueRequestConversation(t *testing.T) {
+	// Spare capacity must not let the reply overwrite the caller's messages
+	messages := make([]Message, 1, 2)
+	messages[0] = Message{Role: "user", Content: "hi"}
+	req := ContinueRequest{Messages: messages, Response: "Hel"}
+
+	conversation := req.conversation()
+	if len(conversation) != 2 || conversation[1].Role != "assistant" || conversation[1].Content != "Hel" {
+		t.Fatalf("expected the reply appended as an assistant message, got %+v", conversation)
+	}
+	conversation[1].Content = "changed"
+	if again := req.conversation(); again[1].Content != "Hel" {
+		t.Errorf("expected the request's messages to be left alone, got %+v", again)
+	}
+}
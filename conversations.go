@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrConversationNotFound is returned when no conversation is stored under a
+// session ID
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ConversationStore persists chat history by session ID, so clients only
+// send the newest turn of a conversation
+type ConversationStore interface {
+	// Append adds messages to the end of the session's conversation,
+	// starting one if needed
+	Append(ctx context.Context, sessionID string, messages ...Message) error
+	// Get returns the session's conversation, or an empty one when there is
+	// none
+	Get(ctx context.Context, sessionID string) ([]Message, error)
+	// Delete drops the session's conversation, returning
+	// ErrConversationNotFound when there is none
+	Delete(ctx context.Context, sessionID string) error
+}
+
+// MemoryConversationStore keeps conversations in memory, forgetting those
+// idle for longer than its TTL
+type MemoryConversationStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	sessions  map[string]*conversation
+	lastSweep time.Time
+}
+
+type conversation struct {
+	messages []Message
+	lastUsed time.Time
+}
+
+// NewMemoryConversationStore creates a store that evicts conversations idle
+// for ttl
+func NewMemoryConversationStore(ttl time.Duration) *MemoryConversationStore {
+	return &MemoryConversationStore{
+		ttl:       ttl,
+		sessions:  make(map[string]*conversation),
+		lastSweep: time.Now(),
+	}
+}
+
+// Append adds messages to the session's conversation
+func (s *MemoryConversationStore) Append(_ context.Context, sessionID string, messages ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepIfDue(now)
+	conv, ok := s.live(sessionID, now)
+	if !ok {
+		conv = &conversation{}
+		s.sessions[sessionID] = conv
+	}
+	conv.messages = append(conv.messages, messages...)
+	conv.lastUsed = now
+	return nil
+}
+
+// Get returns a copy of the session's conversation
+func (s *MemoryConversationStore) Get(_ context.Context, sessionID string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepIfDue(now)
+	conv, ok := s.live(sessionID, now)
+	if !ok {
+		return nil, nil
+	}
+	conv.lastUsed = now
+	return append([]Message(nil), conv.messages...), nil
+}
+
+// Delete drops the session's conversation
+func (s *MemoryConversationStore) Delete(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live(sessionID, time.Now()); !ok {
+		return ErrConversationNotFound
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+// live returns the session's conversation unless it has expired, in which
+// case it is dropped. The caller must hold s.mu.
+func (s *MemoryConversationStore) live(sessionID string, now time.Time) (*conversation, bool) {
+	conv, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, false
+	}
+	if now.Sub(conv.lastUsed) > s.ttl {
+		delete(s.sessions, sessionID)
+		return nil, false
+	}
+	return conv, true
+}
+
+// sweepIfDue drops every expired conversation, at most once per TTL, bounding
+// memory held by abandoned sessions. The caller must hold s.mu.
+func (s *MemoryConversationStore) sweepIfDue(now time.Time) {
+	if now.Sub(s.lastSweep) <= s.ttl {
+		return
+	}
+	for id, conv := range s.sessions {
+		if now.Sub(conv.lastUsed) > s.ttl {
+			delete(s.sessions, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// deleteConversationHandler serves DELETE /api/chat/:sessionId
+func deleteConversationHandler(store ConversationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.Param("sessionId")
+		if err := store.Delete(c.Request.Context(), sessionID); err != nil {
+			if errors.Is(err, ErrConversationNotFound) {
+				respondErrorCode(c, CodeNotFound, err.Error(), nil)
+				return
+			}
+			respondErrorCode(c, CodeInternal, err.Error(), nil)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": sessionID})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// originPolicy is the set of origins allowed to call the API cross-origin.
+// An empty policy allows every origin.
+type originPolicy []string
+
+// allowsAny reports whether the policy is the wildcard default
+func (p originPolicy) allowsAny() bool {
+	return len(p) == 0
+}
+
+// allows reports whether origin may call the API
+func (p originPolicy) allows(origin string) bool {
+	if p.allowsAny() {
+		return true
+	}
+	for _, allowed := range p {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware sets the CORS headers. With no allowlist any origin is
+// allowed without credentials, otherwise a listed origin is echoed back with
+// credentials allowed and other origins get no CORS headers.
+func corsMiddleware(origins originPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		header := c.Writer.Header()
+
+		switch {
+		case origins.allowsAny():
+			header.Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && origins.allows(origin):
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+			header.Add("Vary", "Origin")
+		default:
+			header.Add("Vary", "Origin")
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+		header.Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Accept-Version, Authorization, X-Debug, X-Dry-Run, X-Max-Wait")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// isDeterministic reports whether req always generates the same response,
+// which only holds at temperature 0
+func isDeterministic(req OllamaRequest) bool {
+	return req.Options != nil && req.Options.Temperature != nil && *req.Options.Temperature == 0
+}
+
+// dedupeKey identifies a completion for sharing by what is sent to Ollama
+// and the request fields the proxy acts on itself, which can change the
+// response a caller gets back from the same generation
+func dedupeKey(req PromptRequest, ollamaReq OllamaRequest) string {
+	data, _ := json.Marshal(struct {
+		Ollama           OllamaRequest
+		FallbackModel    string
+		PartialOnTimeout bool
+		ResponseLanguage string
+		Translate        bool
+	}{ollamaReq, req.FallbackModel, req.PartialOnTimeout, req.ResponseLanguage, req.Translate})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// completionGroup lets concurrent identical deterministic completions share
+// one generation
+type completionGroup struct {
+	group singleflight.Group
+}
+
+// do runs generate for key unless an identical generation is already under
+// way, in which case its result is shared. The generation runs detached from
+// any one caller's cancellation, keeping only its deadline, so a caller
+// giving up only stops its own wait and never fails the others. Each caller
+// sharing the result is charged its tokens.
+func (g *completionGroup) do(ctx context.Context, key string, generate func(ctx context.Context) (*OllamaResponse, error)) (*OllamaResponse, error) {
+	leader := false
+	ch := g.group.DoChan(key, func() (any, error) {
+		leader = true
+		detached := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			detached, cancel = context.WithDeadline(detached, deadline)
+			defer cancel()
+		}
+		return generate(detached)
+	})
+
+	select {
+	case res := <-ch:
+		follower := res.Shared && !leader
+		if follower {
+			deduplicatedRequestsTotal.Inc()
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		result := *res.Val.(*OllamaResponse)
+		if follower {
+			// Only the leader's meter saw the generation, every caller that
+			// received it is charged for it
+			meterTokens(ctx, result.EvalCount)
+		}
+		return &result, nil
+	case <-ctx.Done():
+		return nil, wrapRequestError(ctx.Err())
+	}
+}
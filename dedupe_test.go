@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestDedupeKey(t *testing.T) {
+	ollamaReq := OllamaRequest{Model: "llama2", Prompt: "hi"}
+	base := PromptRequest{Prompt: "hi"}
+	key := dedupeKey(base, ollamaReq)
+
+	if dedupeKey(base, ollamaReq) != key {
+		t.Error("expected identical requests to share a key")
+	}
+	tests := []struct {
+		name string
+		req  PromptRequest
+	}{
+		{"partial on timeout", PromptRequest{Prompt: "hi", PartialOnTimeout: true}},
+		{"fallback model", PromptRequest{Prompt: "hi", FallbackModel: "mistral"}},
+		{"response language", PromptRequest{Prompt: "hi", ResponseLanguage: "es"}},
+		{"translate", PromptRequest{Prompt: "hi", ResponseLanguage: "es", Translate: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if dedupeKey(tt.req, ollamaReq) == key {
+				t.Errorf("expected %s to keep the request from sharing another's generation", tt.name)
+			}
+		})
+	}
+}
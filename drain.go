@@ -0,0 +1,20 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// drainMiddleware rejects new requests under prefixes with 503 once draining
+// is set, while requests already accepted run to completion
+func drainMiddleware(draining *atomic.Bool, prefixes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() && hasAnyPrefix(c.Request.URL.Path, prefixes) {
+			c.Header("Connection", "close")
+			abortWithErrorCode(c, CodeShuttingDown, "server is shutting down", nil)
+			return
+		}
+		c.Next()
+	}
+}
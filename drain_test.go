@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDrainMiddleware(t *testing.T) {
+	var draining atomic.Bool
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(drainMiddleware(&draining, "/api/"))
+	router.POST("/api/complete", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/complete", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	const inFlight = 3
+	codes := make([]int, inFlight)
+	var wg sync.WaitGroup
+	for i := range inFlight {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = post().Code
+		}()
+		<-started
+	}
+
+	draining.Store(true)
+	if w := post(); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), string(CodeShuttingDown)) {
+		t.Errorf("expected a new request to get 503 %s while draining, got %d: %s", CodeShuttingDown, w.Code, w.Body.String())
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected in-flight request %d to finish with 200, got %d", i, code)
+		}
+	}
+}
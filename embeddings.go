@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EmbedInput accepts either a single string or a list of strings
+type EmbedInput struct {
+	Texts []string
+	Batch bool
+}
+
+// UnmarshalJSON decodes a JSON string or array of strings
+func (in *EmbedInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*in = EmbedInput{Texts: []string{single}}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	*in = EmbedInput{Texts: list, Batch: true}
+	return nil
+}
+
+// EmbedRequest is our API's embeddings request structure
+type EmbedRequest struct {
+	Input EmbedInput `json:"input"`
+	Model string     `json:"model"`
+}
+
+// EmbedResponse is our API's embeddings response structure. Embedding is set
+// when a single string was sent, Embeddings when a list was sent.
+type EmbedResponse struct {
+	Embedding  []float64   `json:"embedding,omitempty"`
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+	Dimensions int         `json:"dimensions"`
+	Model      string      `json:"model"`
+	Time       string      `json:"time"`
+}
+
+// OllamaEmbeddingRequest represents the request structure for Ollama's embeddings API
+type OllamaEmbeddingRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+// OllamaEmbeddingResponse represents the response from Ollama's embeddings API
+type OllamaEmbeddingResponse struct {
+	Embedding []float64 `json:"embedding"`
+}
+
+// GetEmbedding returns the vector embedding of text
+func (s *LLMService) GetEmbedding(ctx context.Context, text string, model string) ([]float64, error) {
+	if err := s.checkModel(model); err != nil {
+		return nil, err
+	}
+	model = s.ResolveModel(model)
+	defer observeOllamaRequest("embeddings", model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "embeddings", model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/embeddings", OllamaEmbeddingRequest{
+		Model:  model,
+		Prompt: text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embResp OllamaEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		if ctx.Err() != nil {
+			return nil, wrapRequestError(ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to decode ollama embedding response: %w", err)
+	}
+
+	return embResp.Embedding, nil
+}
+
+// GetEmbeddings returns the embedding of every input, in order
+func (s *LLMService) GetEmbeddings(ctx context.Context, inputs []string, model string) ([][]float64, error) {
+	embeddings := make([][]float64, 0, len(inputs))
+	for i, text := range inputs {
+		embedding, err := s.GetEmbedding(ctx, text, model)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	return embeddings, nil
+}
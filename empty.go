@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// emptyResponseNudge is added to the prompt when retrying a completion that
+// came back empty
+const emptyResponseNudge = "\n\nPlease respond to the above."
+
+// ErrEmptyResponse is returned when Ollama still generates nothing once the
+// completion has been retried with RETRY_ON_EMPTY
+var ErrEmptyResponse = errors.New("ollama returned an empty response")
+
+// isEmptyResponse reports whether resp finished without generating any
+// visible text. A response cut off by the deadline isn't empty, it is
+// truncated.
+func isEmptyResponse(resp *OllamaResponse) bool {
+	return resp.Done && strings.TrimSpace(resp.Response) == ""
+}
+
+// retryEmpty retries a completion that came back empty once, nudging the
+// model to answer. Raw prompts are sent again unchanged, since they must
+// keep the model's own format.
+func (s *LLMService) retryEmpty(ctx context.Context, req PromptRequest) (*OllamaResponse, error) {
+	slog.WarnContext(ctx, "ollama returned an empty response, retrying", "model", s.ResolveModel(req.Model))
+	if !req.Raw {
+		req.Prompt += emptyResponseNudge
+	}
+
+	resp, err := s.generateWithFallback(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if isEmptyResponse(resp) {
+		return nil, ErrEmptyResponse
+	}
+	return resp, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors returned by LLMService, wrapping the underlying cause so handlers
+// can map them to HTTP statuses
+var (
+	// ErrModelNotFound is returned when Ollama doesn't have the requested model
+	ErrModelNotFound = errors.New("model not found")
+	// ErrOllamaUnavailable is returned when Ollama can't be reached or fails
+	// with a server error
+	ErrOllamaUnavailable = errors.New("ollama unavailable")
+	// ErrTimeout is returned when Ollama doesn't answer in time
+	ErrTimeout = errors.New("ollama request timed out")
+	// ErrBadRequest is returned when Ollama rejects the request as invalid
+	ErrBadRequest = errors.New("bad request")
+)
+
+// StatusError is returned when Ollama answers with a non-200 status. It
+// unwraps to the typed error matching the status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is Ollama's own Retry-After hint, if it sent one
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ollama returned status %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch {
+	case isModelLoading(e.StatusCode, e.Body):
+		return ErrModelLoading
+	case e.StatusCode == http.StatusNotFound:
+		return ErrModelNotFound
+	case e.StatusCode >= 500:
+		return ErrOllamaUnavailable
+	case e.StatusCode >= 400:
+		return ErrBadRequest
+	default:
+		return nil
+	}
+}
+
+// wrapRequestError distinguishes a cancelled request from a timed out one so
+// callers can tell a user abort apart from a slow model. Failing to connect,
+// even by timing out, means Ollama is unavailable rather than slow.
+func wrapRequestError(err error) error {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("ollama request cancelled: %w", context.Canceled)
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return fmt.Errorf("%w: %w", ErrOllamaUnavailable, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, context.DeadlineExceeded)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrOllamaUnavailable, err)
+	}
+}
+
+// ErrorCode is the machine-readable code of an error response. Every code
+// is always sent with the same HTTP status.
+type ErrorCode string
+
+// Error codes reported to clients
+const (
+	CodeBadRequest            ErrorCode = "BAD_REQUEST"
+	CodeContextWindowExceeded ErrorCode = "CONTEXT_WINDOW_EXCEEDED"
+	CodeUnauthorized          ErrorCode = "UNAUTHORIZED"
+	CodeModelNotAllowed       ErrorCode = "MODEL_NOT_ALLOWED"
+	CodeNotFound              ErrorCode = "NOT_FOUND"
+	CodeModelNotFound         ErrorCode = "MODEL_NOT_FOUND"
+	CodePayloadTooLarge       ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeContentBlocked        ErrorCode = "CONTENT_BLOCKED"
+	CodeToolLoopLimit         ErrorCode = "TOOL_LOOP_LIMIT"
+	CodeRateLimited           ErrorCode = "RATE_LIMITED"
+	CodeBudgetExhausted       ErrorCode = "TOKEN_BUDGET_EXHAUSTED"
+	CodeCancelled             ErrorCode = "CANCELLED"
+	CodeInternal              ErrorCode = "INTERNAL_ERROR"
+	CodeNotSupported          ErrorCode = "NOT_SUPPORTED"
+	CodeInvalidModelResponse  ErrorCode = "INVALID_MODEL_RESPONSE"
+	CodeOllamaUnavailable     ErrorCode = "OLLAMA_UNAVAILABLE"
+	CodeServerBusy            ErrorCode = "SERVER_BUSY"
+	CodeModelLoading          ErrorCode = "MODEL_LOADING"
+	CodeShuttingDown          ErrorCode = "SHUTTING_DOWN"
+	CodeTimeout               ErrorCode = "TIMEOUT"
+)
+
+// errorCodeStatus is the HTTP status each code is sent with
+var errorCodeStatus = map[ErrorCode]int{
+	CodeBadRequest:            http.StatusBadRequest,
+	CodeContextWindowExceeded: http.StatusBadRequest,
+	CodeUnauthorized:          http.StatusUnauthorized,
+	CodeModelNotAllowed:       http.StatusForbidden,
+	CodeNotFound:              http.StatusNotFound,
+	CodeModelNotFound:         http.StatusNotFound,
+	CodePayloadTooLarge:       http.StatusRequestEntityTooLarge,
+	CodeContentBlocked:        http.StatusUnprocessableEntity,
+	CodeToolLoopLimit:         http.StatusUnprocessableEntity,
+	CodeRateLimited:           http.StatusTooManyRequests,
+	CodeBudgetExhausted:       http.StatusTooManyRequests,
+	CodeCancelled:             statusCancelled,
+	CodeInternal:              http.StatusInternalServerError,
+	CodeNotSupported:          http.StatusNotImplemented,
+	CodeInvalidModelResponse:  http.StatusBadGateway,
+	CodeOllamaUnavailable:     http.StatusServiceUnavailable,
+	CodeServerBusy:            http.StatusServiceUnavailable,
+	CodeModelLoading:          http.StatusServiceUnavailable,
+	CodeShuttingDown:          http.StatusServiceUnavailable,
+	CodeTimeout:               http.StatusGatewayTimeout,
+}
+
+// Status is the HTTP status the code is sent with
+func (code ErrorCode) Status() int {
+	if status, ok := errorCodeStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCode maps an LLMService error to the code reported to clients
+func errorCode(err error) ErrorCode {
+	var moderationErr *ModerationError
+	switch {
+	case errors.As(err, &moderationErr):
+		return CodeContentBlocked
+	case errors.Is(err, ErrToolLoopLimit):
+		return CodeToolLoopLimit
+	case errors.Is(err, ErrModelNotAllowed):
+		return CodeModelNotAllowed
+	case errors.Is(err, ErrLogprobsUnsupported):
+		return CodeNotSupported
+	case errors.Is(err, ErrEmptyResponse):
+		return CodeInvalidModelResponse
+	case errors.Is(err, ErrServerBusy):
+		return CodeServerBusy
+	case errors.Is(err, ErrModelLoading):
+		return CodeModelLoading
+	case errors.Is(err, ErrOllamaUnavailable):
+		return CodeOllamaUnavailable
+	case errors.Is(err, ErrModelNotFound):
+		return CodeModelNotFound
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrStreamStalled):
+		return CodeTimeout
+	case errors.Is(err, ErrBadRequest):
+		return CodeBadRequest
+	default:
+		return CodeInternal
+	}
+}
+
+// errorStatus maps an LLMService error to the HTTP status reported to clients
+func errorStatus(err error) int {
+	return errorCode(err).Status()
+}
+
+// APIError is the body of every error response, under "error"
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// RequestID is the X-Request-ID the request was answered with, to find
+	// it in the logs
+	RequestID string `json:"requestId,omitempty"`
+	// Details holds what else a client needs to act on the error, such as
+	// the limit that was exceeded
+	Details gin.H `json:"details,omitempty"`
+}
+
+// newAPIError builds the error body for the request on c
+func newAPIError(c *gin.Context, code ErrorCode, message string, details gin.H) gin.H {
+	return gin.H{"error": APIError{
+		Code:      code,
+		Message:   message,
+		RequestID: c.GetString(requestIDContextKey),
+		Details:   details,
+	}}
+}
+
+// respondErrorCode writes an error response with code, the status it
+// belongs to and details, which may be nil
+func respondErrorCode(c *gin.Context, code ErrorCode, message string, details gin.H) {
+	c.JSON(code.Status(), newAPIError(c, code, message, details))
+}
+
+// abortWithErrorCode is respondErrorCode for middleware, stopping the
+// handlers after it
+func abortWithErrorCode(c *gin.Context, code ErrorCode, message string, details gin.H) {
+	c.AbortWithStatusJSON(code.Status(), newAPIError(c, code, message, details))
+}
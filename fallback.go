@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+)
+
+// FallbackModel returns the model to retry req with when its own model fails,
+// or "" when there is none
+func (s *LLMService) FallbackModel(req PromptRequest) string {
+	if req.FallbackModel != "" {
+		return req.FallbackModel
+	}
+	return s.fallbackModel
+}
+
+// shouldFallback reports whether err means the model itself couldn't serve
+// the request: it isn't installed, failed to load, or errored while
+// generating. Cancellations, timeouts and an unreachable Ollama are final.
+func shouldFallback(err error) bool {
+	if errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrGenerationFailed) {
+		return true
+	}
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= 500
+}
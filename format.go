@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidJSONResponse is returned when a JSON-mode completion isn't valid JSON
+var ErrInvalidJSONResponse = errors.New("model response is not valid JSON")
+
+// validateFormat checks that a request's format is either the string "json"
+// or a JSON schema object. An empty format is valid and disables JSON mode.
+func validateFormat(format json.RawMessage) error {
+	trimmed := bytes.TrimSpace(format)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil
+	}
+
+	var mode string
+	if err := json.Unmarshal(trimmed, &mode); err == nil {
+		if mode != "json" {
+			return errors.New(`format must be "json" or a JSON schema object`)
+		}
+		return nil
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(trimmed, &schema); err != nil {
+		return errors.New(`format must be "json" or a JSON schema object`)
+	}
+	return nil
+}
+
+// hasFormat reports whether a request asked for structured JSON output
+func hasFormat(format json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(format)
+	return len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null"))
+}
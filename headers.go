@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// forwardedHeadersKey is the context.Context key holding the inbound headers
+// to pass on to Ollama
+type forwardedHeadersKey struct{}
+
+// parseOllamaHeaders parses OLLAMA_HEADERS, a comma-separated list of
+// name:value pairs sent with every request to Ollama. Values can't contain
+// commas.
+func parseOllamaHeaders(value string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(pair, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("OLLAMA_HEADERS entry %q must be name:value", strings.TrimSpace(pair))
+		}
+		headers.Set(name, strings.TrimSpace(val))
+	}
+	return headers, nil
+}
+
+// headerNames returns the sorted names in header, so configured headers can
+// be logged without their values
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forwardHeadersMiddleware copies the named inbound headers into the request
+// context so every request made to Ollama on its behalf carries them. The
+// request ID is forwarded even when the service generated it.
+func forwardHeadersMiddleware(names []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		forwarded := make(http.Header)
+		for _, name := range names {
+			value := c.GetHeader(name)
+			if value == "" && http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(requestIDHeader) {
+				value = c.GetString(requestIDContextKey)
+			}
+			if value != "" {
+				forwarded.Set(name, value)
+			}
+		}
+		if len(forwarded) > 0 {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), forwardedHeadersKey{}, forwarded))
+		}
+		c.Next()
+	}
+}
+
+// setOllamaHeaders adds the headers forwarded from the inbound request in
+// ctx, the trace context and the static headers to a request bound for
+// Ollama. Static headers win so callers can't override credentials meant for
+// a proxy.
+func (s *LLMService) setOllamaHeaders(ctx context.Context, req *http.Request) {
+	forwarded, _ := ctx.Value(forwardedHeadersKey{}).(http.Header)
+	for name, values := range forwarded {
+		req.Header[name] = values
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepalive is the SSE comment sent as a heartbeat. Clients ignore
+// comments, proxies see traffic.
+const sseKeepalive = ": keepalive\n\n"
+
+// sseHeartbeat writes SSE keepalive comments while a stream waits for its
+// first token, so proxies and load balancers don't close a connection that is
+// only idle because the model is loading or evaluating the prompt. Other
+// writes to the stream go through write until the heartbeat is stopped.
+type sseHeartbeat struct {
+	mu   sync.Mutex
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// startSSEHeartbeat writes a keepalive to c every interval until stopped,
+// calling startStream under the lock before the first so the stream's
+// headers go out with it
+func startSSEHeartbeat(c *gin.Context, interval time.Duration, startStream func()) *sseHeartbeat {
+	h := &sseHeartbeat{done: make(chan struct{})}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-ticker.C:
+			}
+			h.write(func() {
+				startStream()
+				c.Writer.WriteString(sseKeepalive)
+				c.Writer.Flush()
+			})
+		}
+	}()
+	return h
+}
+
+// write runs fn, which writes to the stream, without interleaving it with a
+// keepalive
+func (h *sseHeartbeat) write(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn()
+}
+
+// Stop ends the heartbeat, waiting for a keepalive being written. It may be
+// called more than once.
+func (h *sseHeartbeat) Stop() {
+	h.once.Do(func() { close(h.done) })
+	h.wg.Wait()
+}
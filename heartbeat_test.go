@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSSEHeartbeat(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	started := false
+	heartbeat := startSSEHeartbeat(c, 10*time.Millisecond, func() { started = true })
+
+	time.Sleep(55 * time.Millisecond)
+	heartbeat.Stop()
+	sent := w.Body.String()
+	if !started || strings.Count(sent, sseKeepalive) < 2 {
+		t.Fatalf("expected keepalives after starting the stream, got %q", sent)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	heartbeat.Stop()
+	if w.Body.String() != sent {
+		t.Errorf("expected no keepalives once stopped, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyCaptureBytes is how much of a response body is kept for its preview.
+// Bigger JSON responses are previewed as raw text.
+const historyCaptureBytes = 64 * 1024
+
+// HistoryEntry is a recent completion kept in memory for troubleshooting
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Endpoint  string    `json:"endpoint"`
+	Model     string    `json:"model"`
+	// Prompt and ResponsePreview are truncated to HISTORY_PREVIEW_BYTES
+	Prompt          string  `json:"prompt"`
+	ResponsePreview string  `json:"responsePreview"`
+	DurationMs      float64 `json:"durationMs"`
+	Status          int     `json:"status"`
+}
+
+// CompletionHistory is a ring buffer of the most recent completions. It is
+// for live debugging, so nothing survives a restart.
+type CompletionHistory struct {
+	previewBytes int
+
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+// NewCompletionHistory keeps the last size completions, truncating their
+// prompts and responses to previewBytes
+func NewCompletionHistory(size int, previewBytes int) *CompletionHistory {
+	return &CompletionHistory{previewBytes: previewBytes, entries: make([]HistoryEntry, size)}
+}
+
+// Add records entry, evicting the oldest once the history is full
+func (h *CompletionHistory) Add(entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// List returns the recorded completions newest first, only those for model
+// unless it is empty
+func (h *CompletionHistory) List(model string) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+
+	list := make([]HistoryEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		entry := h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if model == "" || entry.Model == model {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// historyCaptureWriter keeps the start of the response body for its preview
+type historyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *historyCaptureWriter) Write(b []byte) (int, error) {
+	if room := historyCaptureBytes - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *historyCaptureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// record is the middleware adding the completion a route serves to the
+// history, whether it succeeded or not. The request body is buffered and
+// replaced so the handler can still read it, except for multipart uploads
+// which are recorded without their prompt. A nil history records nothing.
+func (h *CompletionHistory) record(c *gin.Context) {
+	if h == nil {
+		c.Next()
+		return
+	}
+
+	startTime := time.Now()
+	var prompt, model string
+	if c.Request.Body != nil && !isMultipart(c.ContentType()) {
+		body, err := io.ReadAll(c.Request.Body)
+		var rest io.Reader = bytes.NewReader(body)
+		if err != nil {
+			rest = io.MultiReader(rest, errorReader{err})
+		}
+		c.Request.Body = io.NopCloser(rest)
+		prompt, model = historyPrompt(body)
+	}
+
+	writer := &historyCaptureWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+
+	if resolved := c.GetString(modelContextKey); resolved != "" {
+		model = resolved
+	}
+	h.Add(HistoryEntry{
+		Time:            startTime.UTC(),
+		RequestID:       c.GetString(requestIDContextKey),
+		Endpoint:        c.FullPath(),
+		Model:           model,
+		Prompt:          truncateUTF8(prompt, h.previewBytes),
+		ResponsePreview: truncateUTF8(historyResponse(writer.body.Bytes()), h.previewBytes),
+		DurationMs:      float64(time.Since(startTime)) / float64(time.Millisecond),
+		Status:          c.Writer.Status(),
+	})
+}
+
+// historyPrompt pulls the prompt and model out of a completion request body.
+// Chats are represented by their last message.
+func historyPrompt(body []byte) (string, string) {
+	var req struct {
+		Prompt   string    `json:"prompt"`
+		Model    string    `json:"model"`
+		Messages []Message `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return string(body), ""
+	}
+	if req.Prompt == "" && len(req.Messages) > 0 {
+		return req.Messages[len(req.Messages)-1].Content, req.Model
+	}
+	return req.Prompt, req.Model
+}
+
+// historyResponse pulls the generated text, or the error, out of a response
+// body. Streams and anything else unrecognised are kept as they were sent.
+func historyResponse(body []byte) string {
+	var resp struct {
+		Response string `json:"response"`
+		Text     string `json:"text"`
+		Error    *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message *Message `json:"message"`
+		Choices []struct {
+			Response string   `json:"response"`
+			Message  *Message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return string(body)
+	}
+	switch {
+	case resp.Response != "":
+		return resp.Response
+	case resp.Text != "":
+		return resp.Text
+	case resp.Error != nil:
+		return resp.Error.Message
+	case resp.Message != nil:
+		return resp.Message.Content
+	case len(resp.Choices) > 0 && resp.Choices[0].Message != nil:
+		return resp.Choices[0].Message.Content
+	case len(resp.Choices) > 0:
+		return resp.Choices[0].Response
+	default:
+		return string(body)
+	}
+}
+
+// historyHandler serves GET /api/history, listing recent completions newest
+// first, filtered to one model with ?model=
+func historyHandler(history *CompletionHistory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries := history.List(c.Query("model"))
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "count": len(entries)})
+	}
+}
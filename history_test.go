@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestCompletionHistoryList(t *testing.T) {
+	history := NewCompletionHistory(3, 10)
+	for _, entry := range []HistoryEntry{
+		{RequestID: "1", Model: "llama2"},
+		{RequestID: "2", Model: "mistral"},
+		{RequestID: "3", Model: "llama2"},
+		{RequestID: "4", Model: "llama2"},
+	} {
+		history.Add(entry)
+	}
+
+	ids := func(entries []HistoryEntry) []string {
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, entry.RequestID)
+		}
+		return ids
+	}
+	if got := ids(history.List("")); len(got) != 3 || got[0] != "4" || got[2] != "2" {
+		t.Errorf("expected the 3 newest entries newest first, got %v", got)
+	}
+	if got := ids(history.List("llama2")); len(got) != 2 || got[0] != "4" || got[1] != "3" {
+		t.Errorf("expected only llama2 entries, got %v", got)
+	}
+}
+
+func TestHistoryResponse(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: `{"response":"hi there","model":"llama2"}`, want: "hi there"},
+		{body: `{"message":{"role":"assistant","content":"hello"}}`, want: "hello"},
+		{body: `{"choices":[{"message":{"role":"assistant","content":"openai"}}]}`, want: "openai"},
+		{body: `{"error":{"code":"MODEL_NOT_FOUND","message":"model not found"}}`, want: "model not found"},
+		{body: "data:{\"token\":\"a\"}\n\n", want: "data:{\"token\":\"a\"}\n\n"},
+	}
+
+	for _, tt := range tests {
+		if got := historyResponse([]byte(tt.body)); got != tt.want {
+			t.Errorf("historyResponse(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+var (
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+)
+
+// isSupportedImage reports whether data starts with a PNG or JPEG signature
+func isSupportedImage(data []byte) bool {
+	return bytes.HasPrefix(data, pngMagic) || bytes.HasPrefix(data, jpegMagic)
+}
+
+// validateImages checks that every image is base64 encoded PNG or JPEG data
+func validateImages(images []string) error {
+	for i, img := range images {
+		data, err := base64.StdEncoding.DecodeString(img)
+		if err != nil {
+			return fmt.Errorf("image %d is not valid base64: %w", i, err)
+		}
+		if !isSupportedImage(data) {
+			return fmt.Errorf("image %d is not a PNG or JPEG image", i)
+		}
+	}
+	return nil
+}
+
+// encodeImageFiles reads uploaded image files and base64 encodes them for Ollama
+func encodeImageFiles(files []*multipart.FileHeader) ([]string, error) {
+	images := make([]string, 0, len(files))
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open upload %s: %w", fh.Filename, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload %s: %w", fh.Filename, err)
+		}
+
+		if !isSupportedImage(data) {
+			return nil, fmt.Errorf("upload %s is not a PNG or JPEG image", fh.Filename)
+		}
+		images = append(images, base64.StdEncoding.EncodeToString(data))
+	}
+	return images, nil
+}
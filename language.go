@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// translationContextKey is the gin.Context key holding the Translation of a
+// response that came back in the wrong language
+const translationContextKey = "translation"
+
+// translateInstruction is sent with a response to translate it, with the
+// language to translate into
+const translateInstruction = "Translate the following text into %s. Reply with the translation only, keeping its formatting.\n\n"
+
+// ErrTranslateWithoutLanguage is returned for a request that asks to
+// translate its response without saying into what
+var ErrTranslateWithoutLanguage = errors.New("translate needs responseLanguage")
+
+// validateTranslate rejects translate where the response can't be
+// translated: without a language, for JSON output that translating would
+// break, and for several choices
+func validateTranslate(req PromptRequest) error {
+	switch {
+	case !req.Translate:
+		return nil
+	case req.ResponseLanguage == "":
+		return ErrTranslateWithoutLanguage
+	case hasFormat(req.Format):
+		return errors.New("translate cannot be combined with format")
+	case req.N > 1:
+		return errors.New("translate cannot be combined with n greater than 1")
+	}
+	return nil
+}
+
+// language is a language responses can be asked for, with what the
+// detection heuristic recognizes it by
+type language struct {
+	name string
+	// scripts are the writing systems the language's letters come from
+	scripts []*unicode.RangeTable
+	// stopwords tell apart languages written in the Latin script
+	stopwords []string
+}
+
+// languages are the languages responseLanguage recognizes, by ISO 639-1 code.
+// Others are still asked for but never detected, so never translated.
+var languages = map[string]language{
+	"en": {name: "English", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"the", "and", "is", "are", "of", "to", "in", "that", "it", "with", "for", "this", "you"}},
+	"es": {name: "Spanish", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"el", "la", "los", "las", "de", "que", "y", "es", "en", "un", "una", "por", "para", "con", "del"}},
+	"fr": {name: "French", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"le", "la", "les", "des", "et", "est", "un", "une", "que", "pour", "dans", "pas", "du", "avec", "vous"}},
+	"de": {name: "German", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "mit", "den", "ich", "sie", "auf"}},
+	"it": {name: "Italian", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"il", "lo", "la", "gli", "che", "di", "e", "è", "un", "una", "per", "non", "con", "sono", "del"}},
+	"pt": {name: "Portuguese", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"o", "a", "os", "as", "de", "que", "e", "é", "um", "uma", "para", "com", "não", "do", "da"}},
+	"nl": {name: "Dutch", scripts: []*unicode.RangeTable{unicode.Latin}, stopwords: []string{"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "met", "voor", "zijn", "ik"}},
+	"ru": {name: "Russian", scripts: []*unicode.RangeTable{unicode.Cyrillic}},
+	"el": {name: "Greek", scripts: []*unicode.RangeTable{unicode.Greek}},
+	"ar": {name: "Arabic", scripts: []*unicode.RangeTable{unicode.Arabic}},
+	"he": {name: "Hebrew", scripts: []*unicode.RangeTable{unicode.Hebrew}},
+	"hi": {name: "Hindi", scripts: []*unicode.RangeTable{unicode.Devanagari}},
+	"th": {name: "Thai", scripts: []*unicode.RangeTable{unicode.Thai}},
+	"zh": {name: "Chinese", scripts: []*unicode.RangeTable{unicode.Han}},
+	"ja": {name: "Japanese", scripts: []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}},
+	"ko": {name: "Korean", scripts: []*unicode.RangeTable{unicode.Hangul}},
+}
+
+// minStopwords is how many stopwords a Latin-script response needs before
+// its language is judged at all
+const minStopwords = 3
+
+// lookupLanguage finds a language by its code or English name, ignoring case
+func lookupLanguage(value string) (string, language, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if lang, ok := languages[value]; ok {
+		return value, lang, true
+	}
+	for code, lang := range languages {
+		if strings.ToLower(lang.name) == value {
+			return code, lang, true
+		}
+	}
+	return "", language{}, false
+}
+
+// languageName is how the model is told the language: the English name of a
+// recognized language, or the value as the client sent it
+func languageName(value string) string {
+	if _, lang, ok := lookupLanguage(value); ok {
+		return lang.name
+	}
+	return strings.TrimSpace(value)
+}
+
+// responseLanguageInstruction is added to the system prompt of a request
+// that sets responseLanguage
+func responseLanguageInstruction(value string) string {
+	return fmt.Sprintf("Always answer in %s, whatever language the prompt is written in.", languageName(value))
+}
+
+// wrongLanguage reports whether text is confidently not in the language
+// value names. Letters are counted by script, and Latin-script languages are
+// told apart by their most common words, so short or mixed text and
+// languages the table doesn't know are never judged wrong.
+func wrongLanguage(text string, value string) bool {
+	code, want, ok := lookupLanguage(value)
+	if !ok {
+		return false
+	}
+
+	letters, inScript := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.In(r, want.scripts...) {
+			inScript++
+		}
+	}
+	if letters == 0 {
+		return false
+	}
+	if inScript*2 < letters {
+		return true
+	}
+	if len(want.stopwords) == 0 {
+		return false
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	scores := make(map[string]int)
+	total := 0
+	for _, word := range words {
+		for c, lang := range languages {
+			for _, stopword := range lang.stopwords {
+				if word == stopword {
+					scores[c]++
+					total++
+					break
+				}
+			}
+		}
+	}
+	if total < minStopwords {
+		return false
+	}
+	for c, score := range scores {
+		if c != code && score > scores[code]*2 {
+			return true
+		}
+	}
+	return false
+}
+
+// Translation is the response as the model first wrote it, when it came
+// back in the wrong language and was translated
+type Translation struct {
+	// Original is the untranslated response
+	Original string `json:"original"`
+	// Language is the language it was translated into
+	Language string `json:"language"`
+	// text is the translation, sent back as the response
+	text string
+}
+
+// Translate translates text into the language value names, with req's model
+func (s *LLMService) Translate(ctx context.Context, req PromptRequest, text string, value string) (string, error) {
+	result, err := s.GetCompletion(ctx, PromptRequest{
+		Prompt:         fmt.Sprintf(translateInstruction, languageName(value)) + text,
+		Model:          req.Model,
+		Options:        req.Options,
+		SkipPromptWrap: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to translate response: %w", err)
+	}
+	return strings.TrimSpace(result.Response), nil
+}
+
+// translateResponse translates result when the request asked for that and
+// it came back in the wrong language, leaving the Translation on c for
+// newResponse. It reports whether the request may proceed, having written an
+// error response otherwise.
+func (h *completionHandler) translateResponse(c *gin.Context, ctx context.Context, req PromptRequest, result *OllamaResponse) bool {
+	if !req.Translate {
+		return true
+	}
+	response, _ := h.finalResponse(req, result)
+	if !wrongLanguage(response, req.ResponseLanguage) {
+		return true
+	}
+
+	text, err := h.llm.Translate(ctx, req, response, req.ResponseLanguage)
+	if err != nil {
+		h.respondGenerationError(c, ctx, err)
+		return false
+	}
+	c.Set(translationContextKey, &Translation{Original: response, Language: languageName(req.ResponseLanguage), text: text})
+	return true
+}
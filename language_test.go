@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWrongLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		language string
+		want     bool
+	}{
+		{"spanish as asked", "El gato está en la casa y duerme todo el día con los perros.", "es", false},
+		{"english instead of spanish", "The cat is in the house and it sleeps all day with the dogs.", "Spanish", true},
+		{"german as asked", "Die Katze ist im Haus und sie schläft mit den Hunden.", "de", false},
+		{"too short to tell", "OK", "fr", false},
+		{"russian as asked", "Кошка спит в доме.", "ru", false},
+		{"english instead of japanese", "The cat is sleeping.", "ja", true},
+		{"japanese as asked", "猫は家で寝ています。", "ja", false},
+		{"unknown language", "The cat is in the house and it sleeps.", "Klingon", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrongLanguage(tt.text, tt.language); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSystemPromptResponseLanguage(t *testing.T) {
+	svc := newTestService(t, "http://localhost")
+	svc.defaultSystem = "You are helpful."
+
+	got := svc.buildOllamaRequest(PromptRequest{Prompt: "hi", ResponseLanguage: "es"}, false).System
+	if got != "You are helpful.\n\nAlways answer in Spanish, whatever language the prompt is written in." {
+		t.Errorf("unexpected system prompt %q", got)
+	}
+	got = svc.buildOllamaRequest(PromptRequest{Prompt: "hi", System: noSystemPrompt, ResponseLanguage: "Esperanto"}, false).System
+	if got != "Always answer in Esperanto, whatever language the prompt is written in." {
+		t.Errorf("unexpected system prompt %q", got)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Response: " Hola mundo\n", Done: true})
+	})
+	svc := newTestService(t, server.URL)
+
+	text, err := svc.Translate(context.Background(), PromptRequest{Model: "mistral"}, "Hello world", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hola mundo" {
+		t.Errorf("expected the trimmed translation, got %q", text)
+	}
+	sent := <-requests
+	if sent.Model != "mistral" || !strings.HasPrefix(sent.Prompt, "Translate the following text into Spanish.") || !strings.HasSuffix(sent.Prompt, "Hello world") {
+		t.Errorf("unexpected translation request: %+v", sent)
+	}
+
+	if err := validateTranslate(PromptRequest{Translate: true}); err != ErrTranslateWithoutLanguage {
+		t.Errorf("expected ErrTranslateWithoutLanguage, got %v", err)
+	}
+}
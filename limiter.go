@@ -0,0 +1,191 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrServerBusy is returned when no generation slot frees up in time
+var ErrServerBusy = errors.New("too many concurrent requests")
+
+const (
+	// queueReportInterval is how often a queued caller is told its position
+	queueReportInterval = 2 * time.Second
+	// releaseIntervalWeight is how much each new interval between freed slots
+	// moves the moving average used for wait estimates
+	releaseIntervalWeight = 0.2
+)
+
+// QueueObserver is told a queued caller's position, starting at 1, and its
+// estimated wait. The estimate is zero until enough slots have freed up to
+// measure one.
+type QueueObserver func(position int, estimatedWait time.Duration)
+
+// queueObserverKey is the context.Context key holding a QueueObserver
+type queueObserverKey struct{}
+
+// WithQueueObserver returns a context whose generations report their queue
+// position to observe while they wait for a slot
+func WithQueueObserver(ctx context.Context, observe QueueObserver) context.Context {
+	return context.WithValue(ctx, queueObserverKey{}, observe)
+}
+
+// Limiter bounds how many generations run against Ollama at once. Callers
+// that find every slot taken wait in a FIFO queue of bounded depth.
+type Limiter struct {
+	capacity int
+	maxDepth int
+	maxWait  time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	// queue holds a chan struct{} per waiting caller, closed when the caller
+	// is handed a slot
+	queue *list.List
+
+	// lastRelease and releaseInterval estimate how often a slot frees up
+	// while callers are waiting
+	lastRelease     time.Time
+	releaseInterval time.Duration
+}
+
+// NewLimiter creates a limiter allowing size concurrent generations. At most
+// maxDepth callers queue for a slot, zero meaning no bound, and each waits at
+// most maxWait.
+func NewLimiter(size int, maxDepth int, maxWait time.Duration) *Limiter {
+	return &Limiter{
+		capacity: size,
+		maxDepth: maxDepth,
+		maxWait:  maxWait,
+		queue:    list.New(),
+	}
+}
+
+// Acquire blocks until a slot is free. It returns an error wrapping
+// ErrServerBusy right away when the queue is full, or when maxWait passes or
+// ctx is done first. Every successful Acquire must be paired with a Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inFlight < l.capacity && l.queue.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.maxDepth > 0 && l.queue.Len() >= l.maxDepth {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: queue is full", ErrServerBusy)
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	observe, _ := ctx.Value(queueObserverKey{}).(QueueObserver)
+	report := func() {
+		if observe != nil {
+			if position, wait, ok := l.position(elem); ok {
+				observe(position, wait)
+			}
+		}
+	}
+	report()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	ticker := time.NewTicker(queueReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ready:
+			return nil
+		case <-ticker.C:
+			report()
+		case <-timer.C:
+			l.leave(elem, ready)
+			return fmt.Errorf("%w: no slot freed within %s", ErrServerBusy, l.maxWait)
+		case <-ctx.Done():
+			l.leave(elem, ready)
+			return fmt.Errorf("%w: %w", ErrServerBusy, ctx.Err())
+		}
+	}
+}
+
+// leave removes a caller that gave up from the queue. If it was handed a slot
+// in the meantime, the slot is passed on.
+func (l *Limiter) leave(elem *list.Element, ready chan struct{}) {
+	l.mu.Lock()
+	select {
+	case <-ready:
+		l.mu.Unlock()
+		l.Release()
+	default:
+		l.queue.Remove(elem)
+		l.mu.Unlock()
+	}
+}
+
+// position returns where elem is in the queue and its estimated wait
+func (l *Limiter) position(elem *list.Element) (int, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	position := 1
+	for e := l.queue.Front(); e != nil; e = e.Next() {
+		if e == elem {
+			return position, time.Duration(position) * l.releaseInterval, true
+		}
+		position++
+	}
+	return 0, 0, false
+}
+
+// Release frees a slot taken by Acquire, handing it to the longest waiting
+// caller if there is one
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	front := l.queue.Front()
+	if front == nil {
+		l.inFlight--
+		l.lastRelease = time.Time{}
+		return
+	}
+
+	now := time.Now()
+	if !l.lastRelease.IsZero() {
+		interval := now.Sub(l.lastRelease)
+		if l.releaseInterval == 0 {
+			l.releaseInterval = interval
+		} else {
+			l.releaseInterval += time.Duration(releaseIntervalWeight * float64(interval-l.releaseInterval))
+		}
+	}
+	l.lastRelease = now
+
+	l.queue.Remove(front)
+	close(front.Value.(chan struct{}))
+}
+
+// InFlight returns the number of generations currently holding a slot
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of callers waiting for a slot
+func (l *Limiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queue.Len()
+}
+
+// Capacity returns the maximum number of concurrent generations
+func (l *Limiter) Capacity() int {
+	return l.capacity
+}
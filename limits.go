@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bodyLimitMiddleware caps request bodies at maxBytes so oversized payloads
+// are rejected while reading instead of being buffered in full. Paths in
+// exempt are left to apply their own limit.
+func bodyLimitMiddleware(maxBytes int64, exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.Body != nil && !skip[c.Request.URL.Path] {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		c.Next()
+	}
+}
+
+// respondBindError writes the error for a request body that failed to bind,
+// using 413 when the body was cut off by bodyLimitMiddleware
+func respondBindError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondErrorCode(c, CodePayloadTooLarge, "request body too large", gin.H{"limit": tooLarge.Limit})
+		return
+	}
+	respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+}
+
+// checkPromptSize rejects the request with 413 when a prompt of size bytes
+// exceeds limit. It reports whether the request may proceed.
+func checkPromptSize(c *gin.Context, size int, limit int) bool {
+	if limit > 0 && size > limit {
+		respondErrorCode(c, CodePayloadTooLarge, "prompt too large", gin.H{
+			"limit": limit,
+			"size":  size,
+		})
+		return false
+	}
+	return true
+}
+
+// chatContentSize returns the combined size of every message's content
+func chatContentSize(messages []Message) int {
+	size := 0
+	for _, m := range messages {
+		size += len(m.Content)
+	}
+	return size
+}
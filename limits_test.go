@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompleteRejectsOversizedPrompt(t *testing.T) {
+	server, _ := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("oversized prompt reached ollama")
+	})
+	completions := &completionHandler{llm: newTestService(t, server.URL), maxPromptBytes: 16}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/complete", completions.handleJSON)
+
+	body := `{"prompt":"` + strings.Repeat("a", 17) + `"}`
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/complete", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d: %s", w.Code, w.Body.String())
+	}
+	var envelope struct {
+		Error struct {
+			Code    ErrorCode      `json:"code"`
+			Details map[string]int `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("failed to decode error envelope: %v", err)
+	}
+	if envelope.Error.Code != CodePayloadTooLarge || envelope.Error.Details["limit"] != 16 || envelope.Error.Details["size"] != 17 {
+		t.Errorf("expected %s with limit 16 and size 17, got %s", CodePayloadTooLarge, w.Body.String())
+	}
+}
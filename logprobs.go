@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxTopLogprobs caps how many alternatives per token a request may ask for
+const maxTopLogprobs = 20
+
+// ErrLogprobsUnsupported is returned when log-probabilities were asked for
+// but Ollama, or the model, doesn't report them
+var ErrLogprobsUnsupported = errors.New("logprobs are not supported by this Ollama version or model")
+
+// OllamaLogprob is the log-probability Ollama reports for a generated token
+type OllamaLogprob struct {
+	Token       string          `json:"token"`
+	Logprob     float64         `json:"logprob"`
+	TopLogprobs []OllamaLogprob `json:"top_logprobs,omitempty"`
+}
+
+// TokenLogprob is a generated token's log-probability, with the most likely
+// alternatives when requested
+type TokenLogprob struct {
+	Token       string         `json:"token"`
+	Logprob     float64        `json:"logprob"`
+	TopLogprobs []TokenLogprob `json:"topLogprobs,omitempty"`
+}
+
+// validateLogprobs checks the requested number of alternatives per token
+func validateLogprobs(req PromptRequest) error {
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		return fmt.Errorf("topLogprobs must be between 0 and %d", maxTopLogprobs)
+	}
+	if req.TopLogprobs > 0 && !req.Logprobs {
+		return errors.New("topLogprobs requires logprobs")
+	}
+	return nil
+}
+
+// newTokenLogprobs converts Ollama's log-probabilities into our API's form
+func newTokenLogprobs(logprobs []OllamaLogprob) []TokenLogprob {
+	if len(logprobs) == 0 {
+		return nil
+	}
+	converted := make([]TokenLogprob, len(logprobs))
+	for i, lp := range logprobs {
+		converted[i] = TokenLogprob{
+			Token:       lp.Token,
+			Logprob:     lp.Logprob,
+			TopLogprobs: newTokenLogprobs(lp.TopLogprobs),
+		}
+	}
+	return converted
+}
@@ -1,22 +1,167 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultOllamaTimeout is used when OLLAMA_TIMEOUT is unset or invalid
+	defaultOllamaTimeout = 10 * time.Minute
+	// defaultDialTimeout is used when OLLAMA_DIAL_TIMEOUT is unset or invalid
+	defaultDialTimeout = 5 * time.Second
+	// defaultTLSHandshakeTimeout is used when OLLAMA_TLS_HANDSHAKE_TIMEOUT is unset or invalid
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	// defaultResponseHeaderTimeout is used when OLLAMA_RESPONSE_HEADER_TIMEOUT
+	// is unset or invalid. Non-streaming generations only send headers once
+	// they finish, so this must allow for a full generation.
+	defaultResponseHeaderTimeout = 5 * time.Minute
+	// defaultMaxIdleConns is used when OLLAMA_MAX_IDLE_CONNS is unset or invalid
+	defaultMaxIdleConns = 100
+	// defaultMaxIdleConnsPerHost is used when OLLAMA_MAX_IDLE_CONNS_PER_HOST
+	// is unset or invalid. Go's default of 2 has every request past the
+	// second open a new connection to a single Ollama.
+	defaultMaxIdleConnsPerHost = 64
+	// defaultIdleConnTimeout is used when OLLAMA_IDLE_CONN_TIMEOUT is unset or invalid
+	defaultIdleConnTimeout = 90 * time.Second
+	// defaultMaxRetries is used when OLLAMA_MAX_RETRIES is unset or invalid
+	defaultMaxRetries = 3
+	// defaultMaxConcurrent is used when MAX_CONCURRENT_REQUESTS is unset or invalid
+	defaultMaxConcurrent = 4
+	// defaultMaxQueueWait is used when MAX_QUEUE_WAIT is unset or invalid
+	defaultMaxQueueWait = 30 * time.Second
+	// defaultMaxQueueDepth is used when MAX_QUEUE_DEPTH is unset or invalid
+	defaultMaxQueueDepth = 32
+	// busyRetryAfter is the Retry-After hint, in seconds, sent when every slot is taken
+	busyRetryAfter = "5"
+	// defaultModelLoadEstimate is the Retry-After sent for a model still
+	// loading before Ollama has reported how long loads take
+	defaultModelLoadEstimate = 10 * time.Second
+	// defaultStreamIdleTimeout is used when STREAM_IDLE_TIMEOUT is unset or invalid
+	defaultStreamIdleTimeout = 30 * time.Second
+	// defaultSSEHeartbeatInterval is used when SSE_HEARTBEAT_INTERVAL is unset or invalid
+	defaultSSEHeartbeatInterval = 15 * time.Second
+	// defaultWarmPoolInterval is used when WARM_POOL_INTERVAL is unset or
+	// invalid. It must be shorter than OLLAMA_IDLE_CONN_TIMEOUT for the
+	// workers' connections to stay open.
+	defaultWarmPoolInterval = 30 * time.Second
+	// noSystemPrompt is the system prompt a request sets to opt out of
+	// DEFAULT_SYSTEM_PROMPT
+	noSystemPrompt = "none"
+	// defaultHistorySize is used when HISTORY_SIZE is unset or invalid
+	defaultHistorySize = 100
+	// defaultHistoryPreviewBytes is used when HISTORY_PREVIEW_BYTES is unset or invalid
+	defaultHistoryPreviewBytes = 500
+	// defaultMaxPromptBytes is used when MAX_PROMPT_BYTES is unset or invalid
+	defaultMaxPromptBytes = 32 * 1024
+	// defaultMaxChatMessages is used when MAX_CHAT_MESSAGES is unset or invalid
+	defaultMaxChatMessages = 256
+	// defaultMaxBodyBytes is used when MAX_BODY_BYTES is unset or invalid
+	defaultMaxBodyBytes = 1024 * 1024
+	// defaultMaxUploadBytes is used when MAX_UPLOAD_BYTES is unset or invalid
+	defaultMaxUploadBytes = 20 * 1024 * 1024
+	// defaultGzipMinBytes is used when GZIP_MIN_BYTES is unset or invalid
+	defaultGzipMinBytes = 1024
+	// defaultLogBodyMaxBytes is used when LOG_BODY_MAX_BYTES is unset or invalid
+	defaultLogBodyMaxBytes = 2 * 1024
+	// defaultRateLimitBurst is used when RATE_LIMIT_BURST is unset or invalid
+	defaultRateLimitBurst = 10
+	// defaultCacheSize is used when CACHE_SIZE is unset or invalid
+	defaultCacheSize = 256
+	// defaultModelCacheTTL is used when MODEL_CACHE_TTL is unset or invalid
+	defaultModelCacheTTL = 30 * time.Second
+	// defaultBackendCooldown is used when OLLAMA_BACKEND_COOLDOWN is unset or invalid
+	defaultBackendCooldown = 10 * time.Second
+	// defaultBreakerThreshold is used when BREAKER_FAILURE_THRESHOLD is unset or invalid
+	defaultBreakerThreshold = 5
+	// defaultBreakerCooldown is used when BREAKER_COOLDOWN is unset or invalid
+	defaultBreakerCooldown = 30 * time.Second
+	// defaultTokenBudgetFlushInterval is used when TOKEN_BUDGET_FLUSH_INTERVAL is unset or invalid
+	defaultTokenBudgetFlushInterval = time.Minute
+	// defaultAuditLogMaxBytes is used when AUDIT_LOG_MAX_BYTES is unset or invalid
+	defaultAuditLogMaxBytes = 100 * 1024 * 1024
+	// defaultAuditLogFlushInterval is used when AUDIT_LOG_FLUSH_INTERVAL is unset or invalid
+	defaultAuditLogFlushInterval = 5 * time.Second
+	// defaultReadinessTTL is used when READINESS_CACHE_TTL is unset or invalid
+	defaultReadinessTTL = 10 * time.Second
+	// defaultReadinessTimeout is used when READINESS_TIMEOUT is unset or invalid
+	defaultReadinessTimeout = 60 * time.Second
+	// defaultMsPerToken is used when MS_PER_TOKEN is unset or invalid
+	defaultMsPerToken = 10
+	// defaultConversationTTL is used when CONVERSATION_TTL is unset or invalid
+	defaultConversationTTL = 30 * time.Minute
+	// defaultToolTimeout is used when TOOL_TIMEOUT is unset or invalid
+	defaultToolTimeout = 30 * time.Second
+	// defaultMaxToolIterations is used when MAX_TOOL_ITERATIONS is unset or invalid
+	defaultMaxToolIterations = 5
+	// defaultSummarizeChunkTokens is used when SUMMARIZE_CHUNK_TOKENS is unset or invalid
+	defaultSummarizeChunkTokens = 1024
+	// defaultSummarizeOverlapTokens is used when SUMMARIZE_OVERLAP_TOKENS is unset or invalid
+	defaultSummarizeOverlapTokens = 64
+	// defaultSummarizeMaxLevels is used when SUMMARIZE_MAX_LEVELS is unset or invalid
+	defaultSummarizeMaxLevels = 2
+	// defaultShutdownGracePeriod is used when SHUTDOWN_GRACE_PERIOD is unset or invalid
+	defaultShutdownGracePeriod = 30 * time.Second
+	// pingTimeout bounds the connectivity check used by the health endpoint
+	pingTimeout = 5 * time.Second
+	// initialRetryBackoff is the delay before the first retry, doubled after each attempt
+	initialRetryBackoff = 500 * time.Millisecond
 )
 
 // OllamaRequest represents the request structure for Ollama API
 type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
+	Model   string             `json:"model"`
+	Prompt  string             `json:"prompt"`
+	Suffix  string             `json:"suffix,omitempty"`
+	System  string             `json:"system,omitempty"`
+	Stream  bool               `json:"stream"`
+	Options *GenerationOptions `json:"options,omitempty"`
+	Format  json.RawMessage    `json:"format,omitempty"`
+	// KeepAlive is a duration string like "10m" or a number of seconds, -1
+	// keeps the model loaded indefinitely
+	KeepAlive json.RawMessage `json:"keep_alive,omitempty"`
+	Context   []int           `json:"context,omitempty"`
+	Images    []string        `json:"images,omitempty"`
+	Raw       bool            `json:"raw,omitempty"`
+	// Logprobs asks for the log-probability of every generated token, with
+	// TopLogprobs alternatives each
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+}
+
+// GenerationOptions holds the sampling parameters forwarded to Ollama. Unset
+// fields are omitted so Ollama keeps its own defaults.
+type GenerationOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	NumCtx      *int     `json:"num_ctx,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 // OllamaResponse represents the response from Ollama API
@@ -24,12 +169,98 @@ type OllamaResponse struct {
 	Model     string `json:"model"`
 	CreatedAt string `json:"created_at"`
 	Response  string `json:"response"`
+	Done      bool   `json:"done"`
+	// Error is set instead of done when generation fails part way through
+	Error string `json:"error"`
+	// Context encodes the conversation so far and can be sent back to continue it
+	Context []int `json:"context"`
+	// Logprobs is only reported when requested, by Ollama versions that
+	// support it
+	Logprobs []OllamaLogprob `json:"logprobs,omitempty"`
+	// TruncatedByServer is set by us, not Ollama, when the response was cut
+	// off at MAX_RESPONSE_BYTES
+	TruncatedByServer bool `json:"-"`
+	// Stalled is set by us when Ollama stopped streaming for
+	// STREAM_IDLE_TIMEOUT and the response was cut off there
+	Stalled bool `json:"-"`
+	// StoppedAtSequence is set by us when we cut the response at a stop
+	// sequence the backend left in
+	StoppedAtSequence bool `json:"-"`
+
+	// DoneReason says why generation ended, such as stop, length or load
+	DoneReason string `json:"done_reason"`
+
+	// Set on the final response only, durations are in nanoseconds
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalCount          int   `json:"eval_count"`
+	EvalDuration       int64 `json:"eval_duration"`
 }
 
 // PromptRequest is our API's request structure
 type PromptRequest struct {
 	Prompt string `json:"prompt" binding:"required"`
 	Model  string `json:"model"`
+	// System overrides DEFAULT_SYSTEM_PROMPT, and "none" sends no system
+	// prompt at all
+	System  string             `json:"system"`
+	Options *GenerationOptions `json:"options"`
+	// Suffix is the text after the insertion point for fill-in-the-middle
+	// completion: Prompt is the code before the cursor and the model
+	// generates what goes between them. The model must support insertion.
+	Suffix string `json:"suffix"`
+	// Stop ends generation as soon as the model produces any of these
+	// strings. The stop string itself is not part of the response.
+	Stop []string `json:"stop"`
+	// NumCtx sets the context window size in tokens, overriding options.num_ctx
+	NumCtx *int `json:"numCtx"`
+	// Format is either "json" or a JSON schema the response must follow
+	Format json.RawMessage `json:"format"`
+	// KeepAlive controls how long the model stays loaded after the request,
+	// as a duration string or a number of seconds (-1 for forever)
+	KeepAlive json.RawMessage `json:"keepAlive"`
+	// Context is the array returned by a previous completion, continuing
+	// that conversation without resending it
+	Context []int `json:"context"`
+	// Images are base64-encoded PNG or JPEG images for vision models
+	Images []string `json:"images"`
+	// FallbackModel is tried once if Model can't be found or fails to load,
+	// overriding the service's FALLBACK_MODEL
+	FallbackModel string `json:"fallbackModel"`
+	// StripThinking removes reasoning blocks such as <think>...</think>
+	// from the response
+	StripThinking bool `json:"stripThinking"`
+	// PartialOnTimeout returns the text generated so far, marked truncated,
+	// when the deadline passes mid-generation instead of failing with 504
+	PartialOnTimeout bool `json:"partialOnTimeout"`
+	// Raw sends the prompt to the model as is, without the model's prompt
+	// template. Ollama ignores system and template in raw mode, so raw can't
+	// be combined with System.
+	Raw bool `json:"raw"`
+	// AutoSummarize condenses a prompt too long for the context window by
+	// summarizing it in chunks with the model, then completes against the
+	// summaries
+	AutoSummarize bool `json:"autoSummarize"`
+	// N asks for several sampled completions, returned as choices. It needs
+	// a non-zero temperature.
+	N int `json:"n"`
+	// Logprobs returns the log-probability of every generated token, along
+	// with the TopLogprobs most likely alternatives. Requests fail with 501
+	// when Ollama or the model can't report them.
+	Logprobs    bool `json:"logprobs"`
+	TopLogprobs int  `json:"topLogprobs"`
+	// SkipPromptWrap sends the prompt without the deployment's PROMPT_PREFIX
+	// and PROMPT_SUFFIX, for trusted clients that build the full prompt
+	SkipPromptWrap bool `json:"skipPromptWrap"`
+	// ResponseLanguage, a language code such as "es" or a name such as
+	// "Spanish", adds an instruction to the system prompt to answer in it
+	ResponseLanguage string `json:"responseLanguage"`
+	// Translate has the model translate the response into ResponseLanguage
+	// when a heuristic finds it came back in another. How good the
+	// translation is depends on the model.
+	Translate bool `json:"translate"`
 }
 
 // PromptResponse is our API's response structure
@@ -37,123 +268,1804 @@ type PromptResponse struct {
 	Response string `json:"response"`
 	Model    string `json:"model"`
 	Time     string `json:"time"`
+	Usage    Usage  `json:"usage"`
+	// ServedBy is the model that produced the response, which differs from
+	// the requested one when the fallback model was used
+	ServedBy string `json:"servedBy"`
+	// Truncated is set when generation was cut off by the deadline and the
+	// response holds only the text generated so far
+	Truncated bool `json:"truncated,omitempty"`
+	// TruncatedByServer is set along with Truncated when the response was
+	// cut off at the server's MAX_RESPONSE_BYTES
+	TruncatedByServer bool `json:"truncatedByServer,omitempty"`
+	// Stalled is set along with Truncated when the model stopped generating
+	// for STREAM_IDLE_TIMEOUT without finishing
+	Stalled bool `json:"stalled,omitempty"`
+	// FinishReason says why generation stopped, one of the finishReason
+	// values, so clients can tell whether to continue it
+	FinishReason string `json:"finishReason,omitempty"`
+	// Thinking is the stripped reasoning, only included when requested with
+	// ?includeThinking=true
+	Thinking string `json:"thinking,omitempty"`
+	// Context can be sent with the next request to continue the conversation
+	Context []int `json:"context,omitempty"`
+	// ResolvedPrompt is what the model was sent once templates and defaults
+	// were applied, only included when requested with ?includePrompt=true
+	ResolvedPrompt *ResolvedPrompt `json:"resolvedPrompt,omitempty"`
+	// Logprobs holds each generated token's log-probability, only included
+	// when requested
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+	// Summarization reports what autoSummarize did to fit the prompt, only
+	// included when it had to summarize
+	Summarization *SummarizationSteps `json:"summarization,omitempty"`
+	// Translation holds the original response when translate found it in the
+	// wrong language. Response is then the translation.
+	Translation *Translation `json:"translation,omitempty"`
+	// Choices holds every completion when the request set n above 1.
+	// Response is then the first of them and Usage their total.
+	Choices []Choice `json:"choices,omitempty"`
+	// Timings is only included in debug mode
+	Timings *Timings `json:"timings,omitempty"`
+	// Timeout is the adaptive deadline the generation ran under, only
+	// included in debug mode
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ResolvedPrompt is the prompt, suffix, system prompt and options a
+// completion was generated from
+type ResolvedPrompt struct {
+	Prompt string `json:"prompt"`
+	Suffix string `json:"suffix,omitempty"`
+	System string `json:"system,omitempty"`
+	// DefaultSystem is set when System is DEFAULT_SYSTEM_PROMPT rather than
+	// the request's own
+	DefaultSystem bool               `json:"defaultSystem,omitempty"`
+	Options       *GenerationOptions `json:"options,omitempty"`
+}
+
+// Timings is Ollama's timing breakdown for a completion, in milliseconds
+type Timings struct {
+	TotalMs      float64 `json:"totalMs"`
+	LoadMs       float64 `json:"loadMs"`
+	PromptEvalMs float64 `json:"promptEvalMs"`
+	EvalMs       float64 `json:"evalMs"`
+}
+
+// newTimings converts Ollama's nanosecond durations into milliseconds
+func newTimings(resp *OllamaResponse) *Timings {
+	ms := func(ns int64) float64 {
+		return float64(ns) / float64(time.Millisecond)
+	}
+	return &Timings{
+		TotalMs:      ms(resp.TotalDuration),
+		LoadMs:       ms(resp.LoadDuration),
+		PromptEvalMs: ms(resp.PromptEvalDuration),
+		EvalMs:       ms(resp.EvalDuration),
+	}
+}
+
+// ErrRawWithSystem is returned for a raw request that also sets a system
+// prompt, either directly or through responseLanguage
+var ErrRawWithSystem = errors.New("raw cannot be combined with system or responseLanguage, Ollama ignores system in raw mode")
+
+// validateRaw rejects request fields Ollama would silently ignore in raw mode
+func validateRaw(req PromptRequest) error {
+	if req.Raw && (req.System != "" || req.ResponseLanguage != "") {
+		return ErrRawWithSystem
+	}
+	return nil
+}
+
+// isDebug reports whether the caller asked for debug output via ?debug=true
+// or an X-Debug: true header
+func isDebug(c *gin.Context) bool {
+	return c.Query("debug") == "true" || strings.EqualFold(c.GetHeader("X-Debug"), "true")
+}
+
+// isDryRun reports whether the caller asked via ?dryRun=true or an
+// X-Dry-Run: true header to see the Ollama payload instead of running it
+func isDryRun(c *gin.Context) bool {
+	return c.Query("dryRun") == "true" || strings.EqualFold(c.GetHeader("X-Dry-Run"), "true")
+}
+
+// Usage reports token counts and throughput for a completion
+type Usage struct {
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TokensPerSecond  float64 `json:"tokensPerSecond"`
+}
+
+// newUsage computes usage from Ollama's final response. eval_duration is in
+// nanoseconds.
+func newUsage(resp *OllamaResponse) Usage {
+	usage := Usage{
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+	}
+	if resp.EvalDuration > 0 {
+		usage.TokensPerSecond = float64(resp.EvalCount) / time.Duration(resp.EvalDuration).Seconds()
+	}
+	return usage
+}
+
+// Reasons a completion's finishReason gives for generation stopping
+const (
+	// finishStop is the model ending its response, or reaching a stop
+	// sequence Ollama removed itself, which it reports the same way
+	finishStop = "stop"
+	// finishStopSequence is the response being cut at one of the request's
+	// stop sequences
+	finishStopSequence = "stopSequence"
+	// finishLength is the response reaching num_predict or the context window
+	finishLength = "length"
+	// finishLoad and finishUnload are Ollama only loading or unloading the
+	// model, without generating
+	finishLoad   = "load"
+	finishUnload = "unload"
+	// finishTimeout is the deadline cutting the response off, with
+	// partialOnTimeout set
+	finishTimeout = "timeout"
+	// finishResponseLimit is the response reaching MAX_RESPONSE_BYTES
+	finishResponseLimit = "responseLimit"
+	// finishStalled is the model going quiet for STREAM_IDLE_TIMEOUT
+	finishStalled = "stalled"
+)
+
+// finishReason maps how resp ended to one of the finishReason values
+func finishReason(resp *OllamaResponse) string {
+	switch {
+	case resp.TruncatedByServer:
+		return finishResponseLimit
+	case resp.Stalled:
+		return finishStalled
+	case !resp.Done:
+		return finishTimeout
+	case resp.StoppedAtSequence:
+		return finishStopSequence
+	}
+	return doneReasonFinish(resp.DoneReason)
+}
+
+// doneReasonFinish maps the done_reason Ollama ended a generation with to a
+// finishReason value. Reasons that aren't known here are passed through.
+func doneReasonFinish(doneReason string) string {
+	switch doneReason {
+	case "stop":
+		return finishStop
+	case "length":
+		return finishLength
+	case "load":
+		return finishLoad
+	case "unload":
+		return finishUnload
+	default:
+		return doneReason
+	}
+}
+
+// StreamToken is a single token event sent by the streaming endpoint
+type StreamToken struct {
+	Token string `json:"token"`
+}
+
+// QueuePosition is sent as a "queued" event while a stream waits for a slot
+type QueuePosition struct {
+	Position int `json:"position"`
+	// EstimatedWait is omitted until there is enough history to estimate it
+	EstimatedWait string `json:"estimatedWait,omitempty"`
+}
+
+// estimatedWaitString formats a queue wait estimate, empty when unknown
+func estimatedWaitString(wait time.Duration) string {
+	if wait <= 0 {
+		return ""
+	}
+	return wait.Round(time.Second).String()
+}
+
+// StreamDone is the final event sent by the streaming endpoint
+type StreamDone struct {
+	Model string `json:"model"`
+	Time  string `json:"time"`
+	// TruncatedByServer is set when the stream was cut off at
+	// MAX_RESPONSE_BYTES
+	TruncatedByServer bool `json:"truncatedByServer,omitempty"`
+	// Stalled is set when the model stopped generating for
+	// STREAM_IDLE_TIMEOUT without finishing
+	Stalled bool `json:"stalled,omitempty"`
 }
 
 // LLMService handles communication with the Ollama service
 type LLMService struct {
-	ollamaURL    string
-	httpClient   *http.Client
-	defaultModel string
+	backends          *backendPool
+	httpClient        *http.Client
+	timeout           time.Duration
+	defaultModel      string
+	fallbackModel     string
+	aliases           map[string]string
+	headers           http.Header
+	maxRetries        int
+	limiter           *Limiter
+	keepAlive         json.RawMessage
+	breaker           *gobreaker.CircuitBreaker
+	moderator         Moderator
+	retryOnEmpty      bool
+	maxResponseBytes  int
+	modelLoadWait     time.Duration
+	promptPrefix      string
+	promptSuffix      string
+	defaultSystem     string
+	streamIdleTimeout time.Duration
+	modelPolicy       *ModelPolicy
+	warmPool          *WarmPool
+	normalizer        *PromptNormalizer
+
+	// longestLoad is the longest model load Ollama has reported, in
+	// nanoseconds
+	longestLoad atomic.Int64
+
+	// defaults and transforms are swapped atomically when the config file
+	// reloads
+	defaults   atomic.Pointer[OptionDefaults]
+	transforms atomic.Pointer[Transforms]
+}
+
+// LLMConfig holds the settings used to build an LLMService
+type LLMConfig struct {
+	// OllamaURLs are the base URLs of the Ollama backends, used round-robin
+	OllamaURLs []string
+	// BackendCooldown is how long a backend that failed to connect is skipped
+	BackendCooldown time.Duration
+	// DefaultModel is used when a request doesn't name a model
+	DefaultModel string
+	// ModelAliases maps logical model names clients may use to Ollama models
+	ModelAliases map[string]string
+	// Headers are sent with every request to Ollama, such as credentials
+	// for a reverse proxy in front of it
+	Headers http.Header
+	// FallbackModel is tried when a request's model fails, unless the request
+	// names its own fallback
+	FallbackModel string
+	// Timeout is the deadline for an Ollama call, across its retries and
+	// including reading the response body
+	Timeout time.Duration
+	// DialTimeout bounds connecting to Ollama
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake with Ollama
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for Ollama's response headers
+	ResponseHeaderTimeout time.Duration
+	// MaxIdleConns and MaxIdleConnsPerHost bound the keep-alive connections
+	// pooled for reuse, in total and to each Ollama, zero leaving Go's
+	// defaults
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes pooled connections left unused this long
+	IdleConnTimeout time.Duration
+	// MaxRetries is how many times a transient failure is retried
+	MaxRetries int
+	// MaxConcurrent caps how many generations run at once
+	MaxConcurrent int
+	// MaxQueueWait is how long a generation waits for a free slot
+	MaxQueueWait time.Duration
+	// MaxQueueDepth caps how many generations wait for a slot, zero for no cap
+	MaxQueueDepth int
+	// DefaultKeepAlive is sent when a request doesn't set its own keep_alive
+	DefaultKeepAlive string
+	// BreakerThreshold is how many consecutive failures open the circuit
+	// breaker, zero disables it
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open before probing
+	BreakerCooldown time.Duration
+	// Moderator screens prompts and responses, nil lets everything through
+	Moderator Moderator
+	// RetryOnEmpty retries a completion once when Ollama generates nothing
+	RetryOnEmpty bool
+	// MaxResponseBytes cuts off generated responses at this length, zero
+	// for no cap. Chats aren't capped.
+	MaxResponseBytes int
+	// ModelLoadWait is how long a request waits for a model Ollama is still
+	// loading, zero fails it with 503 straight away
+	ModelLoadWait time.Duration
+	// PromptPrefix and PromptSuffix are wrapped around every prompt, such as
+	// a disclaimer or formatting instruction clients don't need to know of
+	PromptPrefix string
+	PromptSuffix string
+	// DefaultSystemPrompt is sent as the system prompt of requests that
+	// don't set their own, and prepended to chats without a system message
+	DefaultSystemPrompt string
+	// StreamIdleTimeout abandons a stream once Ollama has sent nothing for
+	// this long after its first token, zero never does
+	StreamIdleTimeout time.Duration
+	// AllowedModels, when set, are the only models requests may use, and
+	// BlockedModels are models they may never use, both as globs
+	AllowedModels []string
+	BlockedModels []string
+	// WarmPoolSize is how many workers keep a connection to Ollama open and
+	// the default model loaded, zero for none
+	WarmPoolSize int
+	// PromptNormalizer cleans up the text of prompts and chat messages, nil
+	// sending them as they came
+	PromptNormalizer *PromptNormalizer
 }
 
 // NewLLMService creates a new service
-func NewLLMService(ollamaURL string, defaultModel string) *LLMService {
+func NewLLMService(cfg LLMConfig) *LLMService {
+	moderator := cfg.Moderator
+	if moderator == nil {
+		moderator = noopModerator{}
+	}
+
 	return &LLMService{
-		ollamaURL:    ollamaURL,
-		httpClient:   &http.Client{Timeout: 60 * time.Second},
-		defaultModel: defaultModel,
+		backends:          newBackendPool(cfg.OllamaURLs, cfg.BackendCooldown),
+		httpClient:        &http.Client{Transport: newOllamaTransport(cfg)},
+		timeout:           cfg.Timeout,
+		defaultModel:      cfg.DefaultModel,
+		fallbackModel:     cfg.FallbackModel,
+		aliases:           cfg.ModelAliases,
+		headers:           cfg.Headers,
+		maxRetries:        cfg.MaxRetries,
+		limiter:           NewLimiter(cfg.MaxConcurrent, cfg.MaxQueueDepth, cfg.MaxQueueWait),
+		keepAlive:         keepAliveValue(cfg.DefaultKeepAlive),
+		breaker:           newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		moderator:         moderator,
+		retryOnEmpty:      cfg.RetryOnEmpty,
+		maxResponseBytes:  cfg.MaxResponseBytes,
+		modelLoadWait:     cfg.ModelLoadWait,
+		promptPrefix:      cfg.PromptPrefix,
+		promptSuffix:      cfg.PromptSuffix,
+		defaultSystem:     cfg.DefaultSystemPrompt,
+		streamIdleTimeout: cfg.StreamIdleTimeout,
+		modelPolicy:       NewModelPolicy(cfg.AllowedModels, cfg.BlockedModels),
+		normalizer:        cfg.PromptNormalizer,
+		warmPool:          NewWarmPool(cfg.WarmPoolSize, cfg),
+	}
+}
+
+// GetCompletion sends a prompt to Ollama and returns its final response. The
+// request is aborted as soon as ctx is cancelled or its deadline expires. If
+// the model can't serve it, the request is retried once with the fallback
+// model. The prompt and response run through the config file's transforms.
+func (s *LLMService) GetCompletion(ctx context.Context, req PromptRequest) (*OllamaResponse, error) {
+	transforms := s.pipelines()
+	prompt, err := transforms.Pre.Apply(ctx, req.Prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform prompt: %w", err)
+	}
+	req.Prompt = prompt
+	if err := s.checkPrompt(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.generateWithFallback(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if s.retryOnEmpty && isEmptyResponse(resp) {
+		if resp, err = s.retryEmpty(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	filtered, err := transforms.Post.Apply(ctx, resp.Response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform response: %w", err)
+	}
+	if filtered, err = s.moderator.FilterResponse(ctx, filtered); err != nil {
+		return nil, fmt.Errorf("failed to filter response: %w", err)
+	}
+	if filtered != resp.Response {
+		// The tokens would give away what was redacted
+		resp.Logprobs = nil
 	}
+	resp.Response = filtered
+	return resp, nil
 }
 
-// GetCompletion sends a prompt to Ollama and returns the response
-func (s *LLMService) GetCompletion(prompt string, model string) (string, error) {
+// checkPrompt runs the request's prompt and system prompt past the moderator
+func (s *LLMService) checkPrompt(ctx context.Context, req PromptRequest) error {
+	for _, text := range []string{req.System, req.Suffix} {
+		if err := s.moderator.CheckPrompt(ctx, text); err != nil {
+			return err
+		}
+	}
+	return s.moderator.CheckPrompt(ctx, req.Prompt)
+}
+
+// generateWithFallback runs the completion, retrying once with the fallback
+// model if the request's model can't serve it
+func (s *LLMService) generateWithFallback(ctx context.Context, req PromptRequest) (*OllamaResponse, error) {
+	resp, err := s.generate(ctx, req)
+	if err == nil || !shouldFallback(err) {
+		return resp, err
+	}
+
+	fallback := s.FallbackModel(req)
+	if fallback == "" || s.ResolveModel(fallback) == s.ResolveModel(req.Model) {
+		return nil, err
+	}
+	slog.WarnContext(ctx, "model failed, using fallback", "model", s.ResolveModel(req.Model), "fallback", fallback, "error", err)
+	req.Model = fallback
+	return s.generate(ctx, req)
+}
+
+// generate runs a single completion against the request's model
+func (s *LLMService) generate(ctx context.Context, req PromptRequest) (*OllamaResponse, error) {
+	if err := s.checkModel(req.Model); err != nil {
+		return nil, err
+	}
+	if err := s.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+
+	// Streaming keeps what was generated if the deadline passes, and lets a
+	// runaway response be cut off at the cap
+	ollamaReq := s.buildOllamaRequest(req, req.PartialOnTimeout || s.maxResponseBytes > 0)
+	defer observeOllamaRequest("generate", ollamaReq.Model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "generate", ollamaReq.Model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/generate", ollamaReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ollamaResp *OllamaResponse
+	if ollamaReq.Stream {
+		body := watchIdle(resp.Body, s.streamIdleTimeout)
+		defer body.Close()
+		if ollamaResp, err = collectStream(ctx, body, s.maxResponseBytes, req.PartialOnTimeout); err != nil {
+			return nil, err
+		}
+	} else {
+		ollamaResp = &OllamaResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(ollamaResp); err != nil {
+			if ctx.Err() != nil {
+				return nil, wrapRequestError(ctx.Err())
+			}
+			return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+		}
+	}
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrGenerationFailed, ollamaResp.Error)
+	}
+	if ollamaReq.Logprobs && len(ollamaResp.Logprobs) == 0 && ollamaResp.Response != "" {
+		// Older Ollama versions ignore the flag instead of rejecting it
+		return nil, ErrLogprobsUnsupported
+	}
+
+	if ollamaReq.Options != nil {
+		trimmed := trimAtStop(ollamaResp.Response, ollamaReq.Options.Stop)
+		ollamaResp.StoppedAtSequence = len(trimmed) < len(ollamaResp.Response)
+		ollamaResp.Response = trimmed
+	}
+
+	recordTokens(ctx, ollamaReq.Model, ollamaResp.PromptEvalCount, ollamaResp.EvalCount)
+	meterTokens(ctx, ollamaResp.EvalCount)
+	s.observeLoad(ollamaResp.LoadDuration)
+	return ollamaResp, nil
+}
+
+// GetCompletionStream sends a prompt to Ollama in stream mode and invokes
+// onToken for every partial response. Returning an error from onToken stops
+// the stream and that error is returned to the caller. Cancelling ctx closes
+// the upstream connection, as does reaching MAX_RESPONSE_BYTES, after which
+// ErrResponseTruncated is returned, or no token arriving for
+// STREAM_IDLE_TIMEOUT, after which ErrStreamStalled is. The prompt is
+// transformed and moderated, streamed tokens are neither transformed nor
+// filtered.
+func (s *LLMService) GetCompletionStream(ctx context.Context, req PromptRequest, onToken func(string) error) error {
+	prompt, err := s.pipelines().Pre.Apply(ctx, req.Prompt)
+	if err != nil {
+		return fmt.Errorf("failed to transform prompt: %w", err)
+	}
+	req.Prompt = prompt
+	if err := s.checkPrompt(ctx, req); err != nil {
+		return err
+	}
+	if err := s.checkModel(req.Model); err != nil {
+		return err
+	}
+
+	if err := s.limiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+
+	ollamaReq := s.buildOllamaRequest(req, true)
+	defer observeOllamaRequest("generate", ollamaReq.Model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "generate", ollamaReq.Model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/generate", ollamaReq)
+	if err != nil {
+		return err
+	}
+	body := watchIdle(resp.Body, s.streamIdleTimeout)
+	defer body.Close()
+
+	sent, chunks := 0, 0
+	err = readStream(ctx, body, func(line []byte) (bool, error) {
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return false, fmt.Errorf("failed to decode ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return false, fmt.Errorf("%w: %s", ErrGenerationFailed, chunk.Error)
+		}
+		chunks++
+
+		capped := !chunk.Done && s.maxResponseBytes > 0 && sent+len(chunk.Response) >= s.maxResponseBytes
+		if capped {
+			chunk.Response = truncateUTF8(chunk.Response, s.maxResponseBytes-sent)
+		}
+		if chunk.Response != "" {
+			if err := onToken(chunk.Response); err != nil {
+				return false, err
+			}
+			sent += len(chunk.Response)
+		}
+		if capped {
+			recordTokens(ctx, ollamaReq.Model, 0, chunks)
+			meterTokens(ctx, chunks)
+			return false, ErrResponseTruncated
+		}
+
+		if chunk.Done {
+			recordTokens(ctx, ollamaReq.Model, chunk.PromptEvalCount, chunk.EvalCount)
+			meterTokens(ctx, chunk.EvalCount)
+			s.observeLoad(chunk.LoadDuration)
+		}
+		return chunk.Done, nil
+	})
+	if errors.Is(err, ErrStreamStalled) {
+		recordTokens(ctx, ollamaReq.Model, 0, chunks)
+		meterTokens(ctx, chunks)
+	}
+	return err
+}
+
+// readStream reads Ollama's newline-delimited JSON stream, passing each line
+// to handle until it reports the stream is done or returns an error
+func readStream(ctx context.Context, body io.Reader, handle func(line []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		done, err := handle(line)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return wrapRequestError(ctx.Err())
+		}
+		return fmt.Errorf("failed to read ollama stream: %w", err)
+	}
+
+	return fmt.Errorf("ollama stream ended before completion")
+}
+
+// trimAtStop cuts text at the first stop sequence it contains. Ollama already
+// leaves the stop sequence out of its response, this guards against backends
+// that don't.
+func trimAtStop(text string, stops []string) string {
+	cut := len(text)
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(text, stop); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+	return text[:cut]
+}
+
+// SetDefaultOptions replaces the global and per-model options merged into
+// every request
+func (s *LLMService) SetDefaultOptions(defaults OptionDefaults) {
+	s.defaults.Store(&defaults)
+}
+
+// ResolveModel returns the model a request will run against, falling back to
+// the default model when none is named and mapping aliases to their model.
+// Names that aren't aliases are used as is.
+func (s *LLMService) ResolveModel(model string) string {
 	if model == "" {
 		model = s.defaultModel
 	}
+	if target, ok := s.aliases[model]; ok {
+		return target
+	}
+	return model
+}
 
-	reqBody, err := json.Marshal(OllamaRequest{
-		Model:  model,
-		Prompt: prompt,
-	})
+// Aliases returns a copy of the model alias table
+func (s *LLMService) Aliases() map[string]string {
+	aliases := make(map[string]string, len(s.aliases))
+	for alias, model := range s.aliases {
+		aliases[alias] = model
+	}
+	return aliases
+}
+
+// buildOllamaRequest translates our API request into an Ollama generate request
+func (s *LLMService) buildOllamaRequest(req PromptRequest, stream bool) OllamaRequest {
+	req.Prompt = s.normalizer.Normalize(req.Prompt)
+	req.Suffix = s.normalizer.Normalize(req.Suffix)
+	req.System = s.normalizer.Normalize(req.System)
+	ollamaReq := OllamaRequest{
+		Model:       s.ResolveModel(req.Model),
+		Prompt:      s.wrapPrompt(req),
+		Suffix:      req.Suffix,
+		System:      s.systemPrompt(req),
+		Context:     req.Context,
+		Images:      req.Images,
+		Raw:         req.Raw,
+		Stream:      stream,
+		Logprobs:    req.Logprobs,
+		TopLogprobs: req.TopLogprobs,
+		Options:     s.resolveOptions(s.ResolveModel(req.Model), req.Options),
+	}
+	if hasFormat(req.Format) {
+		ollamaReq.Format = req.Format
+	}
+	if len(req.KeepAlive) > 0 {
+		ollamaReq.KeepAlive = req.KeepAlive
+	} else {
+		ollamaReq.KeepAlive = s.keepAlive
+	}
+	if len(req.Stop) > 0 {
+		if ollamaReq.Options == nil {
+			ollamaReq.Options = &GenerationOptions{}
+		}
+		ollamaReq.Options.Stop = append(ollamaReq.Options.Stop, req.Stop...)
+	}
+	if req.NumCtx != nil {
+		if ollamaReq.Options == nil {
+			ollamaReq.Options = &GenerationOptions{}
+		}
+		ollamaReq.Options.NumCtx = req.NumCtx
+	}
+	return ollamaReq
+}
+
+// wrapPrompt surrounds the request's prompt with PROMPT_PREFIX and
+// PROMPT_SUFFIX unless it asked to skip them. Raw prompts are left alone
+// since they must keep the model's own format.
+func (s *LLMService) wrapPrompt(req PromptRequest) string {
+	if req.SkipPromptWrap || req.Raw {
+		return req.Prompt
+	}
+	return s.promptPrefix + req.Prompt + s.promptSuffix
+}
+
+// systemPrompt returns the system prompt sent with req: its own, or
+// DEFAULT_SYSTEM_PROMPT when it has none. The noSystemPrompt sentinel sends
+// none, and raw prompts never get the default since Ollama ignores it.
+func (s *LLMService) systemPrompt(req PromptRequest) string {
+	var system string
+	switch {
+	case req.System == noSystemPrompt:
+	case req.System != "" || req.Raw:
+		system = req.System
+	default:
+		system = s.defaultSystem
+	}
+	if req.ResponseLanguage == "" {
+		return system
+	}
+	return strings.TrimSpace(system + "\n\n" + responseLanguageInstruction(req.ResponseLanguage))
+}
+
+// Ping checks that Ollama is reachable. It makes a single attempt and gives up
+// after a short timeout so it is cheap enough for health probes.
+func (s *LLMService) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	resp, err := s.doOnce(ctx, http.MethodGet, "/api/tags", nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return err
 	}
+	// Reading the body to the end lets the connection be reused, which is
+	// what keeps a warm pool worker's connection open
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
 
-	resp, err := s.httpClient.Post(s.ollamaURL+"/api/generate", "application/json", bytes.NewBuffer(reqBody))
+// post sends a JSON body to the given Ollama endpoint and returns the response
+// when Ollama answers with 200. The caller must close the response body.
+func (s *LLMService) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	reqBody, err := json.Marshal(body)
 	if err != nil {
-		return "", fmt.Errorf("ollama request failed: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	defer resp.Body.Close()
+	return s.do(ctx, http.MethodPost, path, reqBody)
+}
+
+// get issues a GET against the given Ollama endpoint and returns the response
+// when Ollama answers with 200. The caller must close the response body.
+func (s *LLMService) get(ctx context.Context, path string) (*http.Response, error) {
+	return s.do(ctx, http.MethodGet, path, nil)
+}
 
+// do sends a request to Ollama, retrying connection errors and 5xx responses
+// with exponential backoff. The call, retries and reading the response body
+// included, runs under the service's timeout.
+func (s *LLMService) do(ctx context.Context, method string, path string, reqBody []byte) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+
+	call := func() (*http.Response, error) {
+		return s.callBreaker(func() (*http.Response, error) {
+			return s.retry(ctx, method, path, reqBody)
+		})
+	}
+	resp, err := call()
+	if errors.Is(err, ErrModelLoading) && s.modelLoadWait > 0 {
+		resp, err = s.awaitModelLoad(ctx, err, call)
+	}
+	if errors.Is(err, ErrModelLoading) {
+		err = &ModelLoadingError{RetryAfter: s.loadEstimate(err), Err: err}
+	}
+	if err != nil {
+		cancel()
+		failOllamaSpan(ctx, err)
+		return nil, err
+	}
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, ctx: ctx, cancel: cancel}
+	return resp, nil
+}
+
+// retry sends the request, retrying transient failures with exponential backoff
+func (s *LLMService) retry(ctx context.Context, method string, path string, reqBody []byte) (*http.Response, error) {
+	backoff := initialRetryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := s.doOnce(ctx, method, path, reqBody)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt > s.maxRetries || !isRetryable(err) {
+			if attempt > 1 {
+				return nil, fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return nil, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (after %d attempts)", wrapRequestError(ctx.Err()), attempt)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// doOnce performs a single request attempt against Ollama
+func (s *LLMService) doOnce(ctx context.Context, method string, path string, reqBody []byte) (*http.Response, error) {
+	var body io.Reader
+	if reqBody != nil {
+		body = bytes.NewReader(reqBody)
+	}
+
+	b, err := s.backends.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, b.url+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.setOllamaHeaders(ctx, httpReq)
+	if reqBody != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	client := s.httpClient
+	if warm, ok := ctx.Value(warmClientKey{}).(*http.Client); ok {
+		client = warm
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		err = wrapRequestError(err)
+		ollamaSpan(ctx).AddEvent("attempt failed", trace.WithAttributes(attribute.String("server.address", b.url)))
+		if ctx.Err() == nil && !errors.Is(err, ErrTimeout) {
+			// Couldn't reach the backend at all, stop sending it traffic
+			s.backends.markDown(b)
+		}
+		return nil, err
+	}
+
+	ollamaSpan(ctx).SetAttributes(attribute.String("server.address", b.url), attribute.Int("http.response.status_code", resp.StatusCode))
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes), RetryAfter: time.Duration(retryAfter) * time.Second}
 	}
 
-	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode ollama response: %w", err)
+	return resp, nil
+}
+
+// ErrGenerationFailed is returned when Ollama reports an error in place of a
+// response, such as running out of memory part way through a stream
+var ErrGenerationFailed = errors.New("ollama generation failed")
+
+// isRetryable reports whether a failed Ollama call is worth another attempt.
+// Cancellations and timeouts are final, as are 4xx responses which indicate a
+// bad request and having no healthy backend left. A model still loading is
+// left to MODEL_LOAD_WAIT, since backing off briefly won't outlast the load.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrNoHealthyBackend) || errors.Is(err, ErrModelLoading) {
+		return false
 	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
 
-	return ollamaResp.Response, nil
+// respondError writes the JSON error response for a failed generation
+func respondError(c *gin.Context, err error) {
+	var moderationErr *ModerationError
+	if errors.As(err, &moderationErr) {
+		respondErrorCode(c, errorCode(err), err.Error(), gin.H{"category": moderationErr.Category})
+		return
+	}
+	if errors.Is(err, ErrServerBusy) {
+		c.Header("Retry-After", busyRetryAfter)
+	}
+	var loadingErr *ModelLoadingError
+	if errors.As(err, &loadingErr) {
+		c.Header("Retry-After", loadingErr.retryAfterSeconds())
+	}
+	respondErrorCode(c, errorCode(err), err.Error(), nil)
+}
+
+// validateModel rejects the request with 403 when the model policy rules
+// model out, or with 404 when it isn't installed, listing the closest
+// installed names. It reports whether the request may proceed.
+func validateModel(c *gin.Context, validator *ModelValidator, model string) bool {
+	err := validator.Validate(c.Request.Context(), model)
+	if err == nil {
+		return true
+	}
+
+	var unknown *UnknownModelError
+	if errors.As(err, &unknown) {
+		var details gin.H
+		if len(unknown.Suggestions) > 0 {
+			details = gin.H{"suggestions": unknown.Suggestions}
+		}
+		respondErrorCode(c, CodeModelNotFound, unknown.Error(), details)
+	} else if errors.Is(err, ErrModelNotAllowed) {
+		respondErrorCode(c, CodeModelNotAllowed, err.Error(), nil)
+	} else {
+		respondErrorCode(c, CodeInternal, err.Error(), nil)
+	}
+	return false
 }
 
 func main() {
-	// Get configuration from environment variables
-	ollamaURL := os.Getenv("OLLAMA_URL")
-	if ollamaURL == "" {
-		ollamaURL = "http://localhost:11434"
+	// Setup structured logging first so configuration warnings use it
+	slog.SetDefault(newLogger(os.Getenv("LOG_LEVEL")))
+
+	// Export traces when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if !validResponseVersion(responseVersion) {
+		slog.Error("invalid response version built in", "version", responseVersion)
+		os.Exit(1)
 	}
 
-	defaultModel := os.Getenv("DEFAULT_MODEL")
-	if defaultModel == "" {
-		defaultModel = "llama2"
+	// Get configuration from environment variables
+	ollamaURLs := envList("OLLAMA_URL", []string{"http://localhost:11434"})
+	for _, ollamaURL := range ollamaURLs {
+		if err := validateOllamaURL(ollamaURL); err != nil {
+			slog.Error("invalid OLLAMA_URL", "value", ollamaURL, "error", err)
+			os.Exit(1)
+		}
+	}
+	requireOllamaOnStart := envBool("REQUIRE_OLLAMA_ON_START", false)
+	backendCooldown := envDuration("OLLAMA_BACKEND_COOLDOWN", defaultBackendCooldown)
+	defaultModel := envString("DEFAULT_MODEL", "llama2")
+	fallbackModel := os.Getenv("FALLBACK_MODEL")
+	modelAliases, err := parseModelAliases(os.Getenv("MODEL_ALIASES"))
+	if err != nil {
+		slog.Error("invalid MODEL_ALIASES", "error", err)
+		os.Exit(1)
+	}
+	ollamaHeaders, err := parseOllamaHeaders(os.Getenv("OLLAMA_HEADERS"))
+	if err != nil {
+		slog.Error("invalid OLLAMA_HEADERS", "error", err)
+		os.Exit(1)
+	}
+	forwardHeaders := envList("OLLAMA_FORWARD_HEADERS", nil)
+	if len(ollamaHeaders) > 0 || len(forwardHeaders) > 0 {
+		// Only names are logged, static values may hold credentials
+		slog.Info("sending extra headers to ollama", "static", headerNames(ollamaHeaders), "forwarded", forwardHeaders)
+	}
+	ollamaTimeout := envDuration("OLLAMA_TIMEOUT", defaultOllamaTimeout)
+	dialTimeout := envDuration("OLLAMA_DIAL_TIMEOUT", defaultDialTimeout)
+	tlsHandshakeTimeout := envDuration("OLLAMA_TLS_HANDSHAKE_TIMEOUT", defaultTLSHandshakeTimeout)
+	responseHeaderTimeout := envDuration("OLLAMA_RESPONSE_HEADER_TIMEOUT", defaultResponseHeaderTimeout)
+	maxIdleConns := envInt("OLLAMA_MAX_IDLE_CONNS", defaultMaxIdleConns)
+	maxIdleConnsPerHost := envInt("OLLAMA_MAX_IDLE_CONNS_PER_HOST", defaultMaxIdleConnsPerHost)
+	idleConnTimeout := envDuration("OLLAMA_IDLE_CONN_TIMEOUT", defaultIdleConnTimeout)
+	maxRetries := envInt("OLLAMA_MAX_RETRIES", defaultMaxRetries)
+	maxConcurrent := envInt("MAX_CONCURRENT_REQUESTS", defaultMaxConcurrent)
+	if maxConcurrent == 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+	maxQueueWait := envDuration("MAX_QUEUE_WAIT", defaultMaxQueueWait)
+	maxQueueDepth := envInt("MAX_QUEUE_DEPTH", defaultMaxQueueDepth)
+	defaultKeepAlive := os.Getenv("DEFAULT_KEEP_ALIVE")
+	preloadModelNames := envList("PRELOAD_MODELS", nil)
+	preloadKeepAlive := envString("PRELOAD_KEEP_ALIVE", defaultKeepAlive)
+	warmPoolSize := envInt("WARM_POOL_SIZE", 0)
+	if warmPoolSize > maxConcurrent {
+		// Workers past the concurrency limit would never be used
+		slog.Warn("WARM_POOL_SIZE exceeds MAX_CONCURRENT_REQUESTS, capping it", "size", warmPoolSize, "max_concurrent", maxConcurrent)
+		warmPoolSize = maxConcurrent
+	}
+	warmPoolInterval := envDuration("WARM_POOL_INTERVAL", defaultWarmPoolInterval)
+	if warmPoolSize > 0 && warmPoolInterval >= idleConnTimeout {
+		slog.Warn("WARM_POOL_INTERVAL is not shorter than OLLAMA_IDLE_CONN_TIMEOUT, warm connections may be closed between refreshes", "interval", warmPoolInterval.String(), "idle_conn_timeout", idleConnTimeout.String())
+	}
+	retryOnEmpty := envBool("RETRY_ON_EMPTY", false)
+	maxResponseBytes := envInt("MAX_RESPONSE_BYTES", 0)
+	modelLoadWait := envDurationOrZero("MODEL_LOAD_WAIT", 0)
+	promptPrefix := os.Getenv("PROMPT_PREFIX")
+	promptSuffix := os.Getenv("PROMPT_SUFFIX")
+	defaultSystemPrompt := os.Getenv("DEFAULT_SYSTEM_PROMPT")
+	streamIdleTimeout := envDurationOrZero("STREAM_IDLE_TIMEOUT", defaultStreamIdleTimeout)
+	allowedModels := envList("ALLOWED_MODELS", nil)
+	blockedModels := envList("BLOCKED_MODELS", nil)
+	promptNormalizer, err := NewPromptNormalizer(parseNormalizeRules(os.Getenv("NORMALIZE_PROMPTS")))
+	if err != nil {
+		slog.Error("invalid NORMALIZE_PROMPTS", "value", os.Getenv("NORMALIZE_PROMPTS"), "error", err)
+		os.Exit(1)
+	}
+	if len(allowedModels) > 0 || len(blockedModels) > 0 {
+		slog.Info("restricting models", "allowed", allowedModels, "blocked", blockedModels)
+	}
+	sseHeartbeatInterval := envDuration("SSE_HEARTBEAT_INTERVAL", defaultSSEHeartbeatInterval)
+	historySize := envInt("HISTORY_SIZE", defaultHistorySize)
+	historyPreviewBytes := envInt("HISTORY_PREVIEW_BYTES", defaultHistoryPreviewBytes)
+	apiTokens := envList("API_TOKEN", nil)
+	tokenDailyBudget := envInt("TOKEN_DAILY_BUDGET", 0)
+	tokenBudgetFile := os.Getenv("TOKEN_BUDGET_FILE")
+	tokenBudgetFlushInterval := envDuration("TOKEN_BUDGET_FLUSH_INTERVAL", defaultTokenBudgetFlushInterval)
+	allowedOrigins := originPolicy(envList("ALLOWED_ORIGINS", nil))
+	maxPromptBytes := envInt("MAX_PROMPT_BYTES", defaultMaxPromptBytes)
+	maxChatMessages := envInt("MAX_CHAT_MESSAGES", defaultMaxChatMessages)
+	conversationTTL := envDuration("CONVERSATION_TTL", defaultConversationTTL)
+	maxToolIterations := envInt("MAX_TOOL_ITERATIONS", defaultMaxToolIterations)
+	toolClient := &http.Client{Timeout: envDuration("TOOL_TIMEOUT", defaultToolTimeout)}
+	chatTools, err := parseToolEndpoints(os.Getenv("TOOL_ENDPOINTS"), toolClient)
+	if err != nil {
+		slog.Error("invalid TOOL_ENDPOINTS", "error", err)
+		os.Exit(1)
+	}
+	maxBodyBytes := envInt("MAX_BODY_BYTES", defaultMaxBodyBytes)
+	charsPerToken := envFloat("CHARS_PER_TOKEN", defaultCharsPerToken)
+	tokenizer := strings.ToLower(envString("TOKENIZER", tokenizerAuto))
+	if !validTokenizer(tokenizer) {
+		slog.Warn("invalid TOKENIZER, using default", "value", tokenizer, "default", tokenizerAuto)
+		tokenizer = tokenizerAuto
+	}
+	summarize := summarizeConfig{
+		chunkTokens:   envInt("SUMMARIZE_CHUNK_TOKENS", defaultSummarizeChunkTokens),
+		overlapTokens: envInt("SUMMARIZE_OVERLAP_TOKENS", defaultSummarizeOverlapTokens),
+		maxLevels:     envInt("SUMMARIZE_MAX_LEVELS", defaultSummarizeMaxLevels),
+		charsPerToken: charsPerToken,
+	}
+	generationTimeout := adaptiveTimeout{
+		base:          envDuration("BASE_TIMEOUT", 0),
+		perToken:      time.Duration(envFloat("MS_PER_TOKEN", defaultMsPerToken) * float64(time.Millisecond)),
+		max:           envDuration("MAX_TIMEOUT", ollamaTimeout),
+		charsPerToken: charsPerToken,
+	}
+	maxUploadBytes := envInt("MAX_UPLOAD_BYTES", defaultMaxUploadBytes)
+	rateLimitRPS := envFloat("RATE_LIMIT_RPS", 0)
+	rateLimitBurst := envInt("RATE_LIMIT_BURST", defaultRateLimitBurst)
+	cacheSize := envInt("CACHE_SIZE", defaultCacheSize)
+	modelCacheTTL := envDuration("MODEL_CACHE_TTL", defaultModelCacheTTL)
+	breakerThreshold := envInt("BREAKER_FAILURE_THRESHOLD", defaultBreakerThreshold)
+	breakerCooldown := envDuration("BREAKER_COOLDOWN", defaultBreakerCooldown)
+	readinessTTL := envDuration("READINESS_CACHE_TTL", defaultReadinessTTL)
+	readinessTimeout := envDuration("READINESS_TIMEOUT", defaultReadinessTimeout)
+	shutdownGracePeriod := envDuration("SHUTDOWN_GRACE_PERIOD", defaultShutdownGracePeriod)
+	shutdownDrainDelay := envDuration("SHUTDOWN_DRAIN_DELAY", 0)
+	gzipMinBytes := envInt("GZIP_MIN_BYTES", defaultGzipMinBytes)
+	logBodies := envBool("LOG_BODIES", false)
+	logBodyMaxBytes := envInt("LOG_BODY_MAX_BYTES", defaultLogBodyMaxBytes)
+	auditLogPath := os.Getenv("AUDIT_LOG_PATH")
+	auditLogMaxBytes := envInt("AUDIT_LOG_MAX_BYTES", defaultAuditLogMaxBytes)
+	auditLogFlushInterval := envDuration("AUDIT_LOG_FLUSH_INTERVAL", defaultAuditLogFlushInterval)
+	port := envString("PORT", "8080")
+	basePath := normalizeBasePath(os.Getenv("BASE_PATH"))
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	tlsClientCA := os.Getenv("TLS_CLIENT_CA")
+	ginMode := strings.ToLower(envString("GIN_MODE", gin.ReleaseMode))
+	if ginMode != gin.DebugMode && ginMode != gin.ReleaseMode && ginMode != gin.TestMode {
+		slog.Warn("invalid GIN_MODE, using default", "value", ginMode, "default", gin.ReleaseMode)
+		ginMode = gin.ReleaseMode
 	}
+	trustedProxies := envList("TRUSTED_PROXIES", nil)
+	_, restrictProxies := os.LookupEnv("TRUSTED_PROXIES")
+	configFile := os.Getenv("CONFIG_FILE")
+	moderationRulesFile := os.Getenv("MODERATION_RULES_FILE")
+	templatesDir := os.Getenv("TEMPLATES_DIR")
+	thinkingTags := envList("THINKING_TAGS", defaultThinkingTags)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Load the moderation rules, failing fast on a bad file
+	var moderator Moderator
+	if moderationRulesFile != "" {
+		rules, err := LoadModerationRules(moderationRulesFile)
+		if err != nil {
+			slog.Error("invalid moderation rules", "path", moderationRulesFile, "error", err)
+			os.Exit(1)
+		}
+		moderator = rules
 	}
 
 	// Create LLM service
-	llmService := NewLLMService(ollamaURL, defaultModel)
+	llmService := NewLLMService(LLMConfig{
+		OllamaURLs:            ollamaURLs,
+		BackendCooldown:       backendCooldown,
+		DefaultModel:          defaultModel,
+		FallbackModel:         fallbackModel,
+		ModelAliases:          modelAliases,
+		Headers:               ollamaHeaders,
+		Timeout:               ollamaTimeout,
+		DialTimeout:           dialTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxRetries:            maxRetries,
+		MaxConcurrent:         maxConcurrent,
+		MaxQueueWait:          maxQueueWait,
+		MaxQueueDepth:         maxQueueDepth,
+		DefaultKeepAlive:      defaultKeepAlive,
+		BreakerThreshold:      breakerThreshold,
+		BreakerCooldown:       breakerCooldown,
+		Moderator:             moderator,
+		RetryOnEmpty:          retryOnEmpty,
+		MaxResponseBytes:      maxResponseBytes,
+		ModelLoadWait:         modelLoadWait,
+		PromptPrefix:          promptPrefix,
+		PromptSuffix:          promptSuffix,
+		DefaultSystemPrompt:   defaultSystemPrompt,
+		StreamIdleTimeout:     streamIdleTimeout,
+		AllowedModels:         allowedModels,
+		BlockedModels:         blockedModels,
+		PromptNormalizer:      promptNormalizer,
+		WarmPoolSize:          warmPoolSize,
+	})
+
+	// Check Ollama answers before serving. That is only fatal with
+	// REQUIRE_OLLAMA_ON_START, so by default the service can start ahead of
+	// Ollama in an orchestrated deployment.
+	if err := llmService.Ping(context.Background()); err != nil {
+		if requireOllamaOnStart {
+			slog.Error("ollama is unreachable and REQUIRE_OLLAMA_ON_START is set", "ollama_urls", ollamaURLs, "error", err)
+			os.Exit(1)
+		}
+		slog.Warn("ollama is unreachable, starting anyway", "ollama_urls", ollamaURLs, "error", err)
+	}
+
+	// Load organization-wide defaults, failing fast on a bad file, and keep
+	// them up to date as the file changes
+	if configFile != "" {
+		fileConfig, err := LoadConfigFile(configFile)
+		if err != nil {
+			slog.Error("invalid config file", "path", configFile, "error", err)
+			os.Exit(1)
+		}
+		llmService.SetDefaultOptions(fileConfig.OptionDefaults())
+		llmService.SetTransforms(fileConfig.TransformPipelines())
 
-	// Setup Gin router
-	router := gin.Default()
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		if err := WatchConfigFile(watchCtx, configFile, func(cfg *FileConfig) {
+			llmService.SetDefaultOptions(cfg.OptionDefaults())
+			llmService.SetTransforms(cfg.TransformPipelines())
+		}); err != nil {
+			slog.Warn("config file hot reload disabled", "error", err)
+		}
+	}
 
-	// Add CORS middleware
+	// Bring failed backends back into rotation once they answer again
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go llmService.MonitorBackends(monitorCtx, backendCooldown)
+
+	// Load the named prompt templates, failing fast on a bad template
+	var promptTemplates *PromptTemplates
+	if templatesDir != "" {
+		var err error
+		promptTemplates, err = LoadPromptTemplates(templatesDir)
+		if err != nil {
+			slog.Error("invalid prompt templates", "dir", templatesDir, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("loaded prompt templates", "dir", templatesDir, "count", promptTemplates.Len())
+	}
+
+	modelValidator := NewModelValidator(llmService, modelCacheTTL)
+	responseCache := NewResponseCache(cacheSize)
+
+	// Setup Gin router, in release mode unless GIN_MODE says otherwise
+	gin.SetMode(ginMode)
+	router := gin.New()
+
+	// Only believe X-Forwarded-For from TRUSTED_PROXIES so c.ClientIP() is the
+	// real client behind a load balancer. Set but empty trusts no proxy, while
+	// unset keeps Gin's default of trusting every one.
+	if restrictProxies {
+		if err := router.SetTrustedProxies(trustedProxies); err != nil {
+			slog.Error("invalid TRUSTED_PROXIES", "value", os.Getenv("TRUSTED_PROXIES"), "error", err)
+			os.Exit(1)
+		}
+	} else {
+		slog.Warn("TRUSTED_PROXIES is unset, trusting forwarded client IPs from any proxy")
+	}
+	router.Use(gin.CustomRecovery(func(c *gin.Context, _ any) {
+		abortWithErrorCode(c, CodeInternal, "internal server error", nil)
+	}))
+	router.Use(requestIDMiddleware())
+	router.Use(tracingMiddleware())
+	router.Use(loggingMiddleware())
+
+	// Pass the inbound headers named in OLLAMA_FORWARD_HEADERS on to Ollama so
+	// tracing propagates
+	if len(forwardHeaders) > 0 {
+		router.Use(forwardHeadersMiddleware(forwardHeaders))
+	}
+
+	// Record Prometheus metrics for every route
+	router.Use(metricsMiddleware())
+
+	// Count in-flight requests so shutdown can report what it drained
+	var inFlight atomic.Int64
 	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		c.Next()
+	})
+
+	// Turn away new API requests once shutdown begins
+	var draining atomic.Bool
+	router.Use(drainMiddleware(&draining, underBasePath(basePath, "/api/", "/v1/")...))
+
+	// Add CORS middleware, restricted to ALLOWED_ORIGINS when set
+	router.Use(corsMiddleware(allowedOrigins))
+
+	// Let clients set their own deadline with X-Max-Wait, up to MAX_TIMEOUT.
+	// OLLAMA_TIMEOUT still bounds every call to Ollama.
+	router.Use(maxWaitMiddleware(generationTimeout.max))
+
+	// Limit each client IP when RATE_LIMIT_RPS is set, leaving probes and
+	// metrics scrapes alone
+	if rateLimitRPS > 0 {
+		limiter := NewIPRateLimiter(rateLimitRPS, rateLimitBurst)
+		router.Use(rateLimitMiddleware(limiter, underBasePath(basePath, "/health", "/livez", "/readyz", "/metrics")...))
+	}
+
+	// Require a bearer token on the API when API_TOKEN is set. Health probes
+	// and metrics stay open.
+	if len(apiTokens) > 0 {
+		router.Use(authMiddleware(apiTokens, underBasePath(basePath, "/api/", "/v1/")...))
+	}
+
+	// Cap the tokens each API token may generate per UTC day when
+	// TOKEN_DAILY_BUDGET is set, keeping the day's usage in TOKEN_BUDGET_FILE
+	// across restarts
+	var tokenBudget *TokenBudget
+	if tokenDailyBudget > 0 {
+		if len(apiTokens) == 0 {
+			slog.Warn("TOKEN_DAILY_BUDGET has no effect without API_TOKEN")
+		}
+		var err error
+		tokenBudget, err = NewTokenBudget(int64(tokenDailyBudget), tokenBudgetFile)
+		if err != nil {
+			slog.Error("failed to load token budget", "path", tokenBudgetFile, "error", err)
+			os.Exit(1)
+		}
+		router.Use(tokenBudgetMiddleware(tokenBudget))
+	}
+	if tokenBudget != nil && tokenBudgetFile != "" {
+		budgetCtx, stopBudget := context.WithCancel(context.Background())
+		defer stopBudget()
+		go tokenBudget.Run(budgetCtx, tokenBudgetFlushInterval)
+	}
+
+	// Reject oversized bodies before they are fully buffered. Image uploads
+	// have their own, larger limit.
+	router.Use(bodyLimitMiddleware(int64(maxBodyBytes), underBasePath(basePath, "/api/complete/multipart")...))
+
+	// Compress large JSON responses for clients that accept gzip. Setting
+	// GZIP_MIN_BYTES to 0 turns compression off.
+	if gzipMinBytes > 0 {
+		router.Use(gzipMiddleware(gzipMinBytes))
+	}
+
+	// Log request and response bodies when debugging prompts
+	if logBodies {
+		router.Use(bodyLoggingMiddleware(logBodyMaxBytes))
+	}
+
+	// Keep an audit trail of every completion's prompt and response when
+	// AUDIT_LOG_PATH is set, failing fast if it can't be opened
+	var auditLog *AuditLogger
+	if auditLogPath != "" {
+		var err error
+		auditLog, err = NewAuditLogger(auditLogPath, int64(auditLogMaxBytes))
+		if err != nil {
+			slog.Error("failed to open audit log", "path", auditLogPath, "error", err)
+			os.Exit(1)
+		}
+		auditCtx, stopAudit := context.WithCancel(context.Background())
+		defer stopAudit()
+		go auditLog.Run(auditCtx, auditLogFlushInterval)
+	}
+
+	// Register every route under BASE_PATH, for serving behind a reverse
+	// proxy at a subpath. Middleware stays on the router so unmatched paths
+	// still get request IDs, logging and CORS.
+	router.NoRoute(func(c *gin.Context) {
+		respondErrorCode(c, CodeNotFound, "no such endpoint", nil)
+	})
+	routes := router.Group(basePath)
+
+	// Keep the last HISTORY_SIZE completions in memory for GET /api/history.
+	// They include prompts, so they are only kept when API_TOKEN guards them.
+	var history *CompletionHistory
+	if historySize > 0 && len(apiTokens) > 0 {
+		history = NewCompletionHistory(historySize, historyPreviewBytes)
+		routes.GET("/api/history", historyHandler(history))
+	} else if os.Getenv("HISTORY_SIZE") != "" {
+		slog.Warn("HISTORY_SIZE has no effect without API_TOKEN")
+	}
+	completionRoutes := routes.Group("", history.record)
+
+	// Define endpoints for prompt completion
+	completions := &completionHandler{
+		llm:            llmService,
+		validator:      modelValidator,
+		cache:          responseCache,
+		templates:      promptTemplates,
+		thinking:       NewThinkingStripper(thinkingTags),
+		cancels:        newCancelRegistry(),
+		maxPromptBytes: maxPromptBytes,
+		charsPerToken:  charsPerToken,
+		timeout:        generationTimeout,
+		summarize:      summarize,
+		auditLog:       auditLog,
+	}
+	completionRoutes.POST("/api/complete", completions.handleJSON)
+	completionRoutes.POST("/api/complete/multipart", bodyLimitMiddleware(int64(maxUploadBytes)), completions.handleMultipart)
+	completionRoutes.POST("/api/complete/template", completions.handleTemplate)
+	routes.POST("/api/cancel/:id", cancelHandler(completions.cancels))
+
+	// Extend a response that stopped early, from its context or its text
+	completionRoutes.POST("/api/continue", continueHandler(llmService, modelValidator, auditLog, maxPromptBytes))
+
+	// Flush the completion cache
+	routes.DELETE("/api/cache", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"flushed": responseCache.Flush()})
+	})
+
+	// Define endpoint for multi-turn chat, keeping conversations by session
+	// ID for CONVERSATION_TTL after their last turn
+	var conversations ConversationStore = NewMemoryConversationStore(conversationTTL)
+	routes.DELETE("/api/chat/:sessionId", deleteConversationHandler(conversations))
+	completionRoutes.POST("/api/chat", func(c *gin.Context) {
+		var req ChatRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
 			return
 		}
-		c.Next()
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		// Continue the stored conversation when the request names a session
+		turn := req.Messages
+		if req.SessionID != "" {
+			history, err := conversations.Get(c.Request.Context(), req.SessionID)
+			if err != nil {
+				respondErrorCode(c, CodeInternal, err.Error(), nil)
+				return
+			}
+			req.Messages = append(history, turn...)
+		}
+		c.Set(promptLengthContextKey, chatContentSize(req.Messages))
+
+		if !checkPromptSize(c, chatContentSize(req.Messages), maxPromptBytes) {
+			return
+		}
+
+		if !checkChatLength(c, req.Messages, maxChatMessages) {
+			return
+		}
+
+		dropped := 0
+		if req.Truncate {
+			window := llmService.contextWindow(req.Model, req.Options)
+			messages, n, ok := trimChatHistory(req.Messages, window, charsPerToken)
+			if !ok {
+				respondErrorCode(c, CodeContextWindowExceeded, "system prompt and latest message likely exceed the context window", gin.H{"numCtx": window})
+				return
+			}
+			req.Messages, dropped = messages, n
+		}
+
+		if !validateModel(c, modelValidator, req.Model) {
+			return
+		}
+
+		// Run the tools registered in TOOL_ENDPOINTS until the model answers
+		startTime := time.Now()
+		result, trace, err := llmService.GetChatCompletionWithTools(c.Request.Context(), req, chatTools, maxToolIterations)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		usage := Usage{PromptTokens: result.PromptEvalCount, CompletionTokens: result.EvalCount}
+		auditLog.record(c, AuditRecord{
+			Model:    result.Model,
+			Messages: slices.Concat(req.Messages, trace[:len(trace)-1]),
+			Response: result.Message.Content,
+			Usage:    &usage,
+		})
+
+		if req.SessionID != "" {
+			if err := conversations.Append(c.Request.Context(), req.SessionID, append(turn, trace...)...); err != nil {
+				slog.WarnContext(c.Request.Context(), "failed to store conversation", "session_id", req.SessionID, "error", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, ChatResponse{
+			Message:         result.Message,
+			Model:           req.Model,
+			Time:            time.Since(startTime).String(),
+			SessionID:       req.SessionID,
+			Truncated:       dropped > 0,
+			DroppedMessages: dropped,
+			Trace:           toolTrace(trace),
+		})
 	})
 
-	// Define endpoint for prompt completion
-	router.POST("/api/complete", func(c *gin.Context) {
+	// Define endpoint for vector embeddings
+	routes.POST("/api/embed", func(c *gin.Context) {
+		var req EmbedRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		if len(req.Input.Texts) == 0 {
+			respondErrorCode(c, CodeBadRequest, "input must not be empty", nil)
+			return
+		}
+
+		startTime := time.Now()
+		embeddings, err := llmService.GetEmbeddings(c.Request.Context(), req.Input.Texts, req.Model)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		resp := EmbedResponse{
+			Dimensions: len(embeddings[0]),
+			Model:      req.Model,
+			Time:       time.Since(startTime).String(),
+		}
+		if req.Input.Batch {
+			resp.Embeddings = embeddings
+		} else {
+			resp.Embedding = embeddings[0]
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+
+	// Estimate a prompt's token count for client-side budgeting
+	routes.POST("/api/tokenize", tokenizeHandler(llmService, tokenizer, charsPerToken))
+
+	// Measure a model's throughput. A benchmark ties up the model for many
+	// generations, so it is only offered when API_TOKEN guards it.
+	if len(apiTokens) > 0 {
+		routes.POST("/api/benchmark", benchmarkHandler(llmService, modelValidator, maxPromptBytes))
+	}
+
+	// Low-level passthrough to Ollama's generate API
+	completionRoutes.POST("/api/raw", rawHandler(llmService))
+
+	// Complete several independent prompts concurrently
+	completionRoutes.POST("/api/batch", batchHandler(llmService, modelValidator, maxPromptBytes))
+
+	// Bidirectional streaming chat over a WebSocket
+	routes.GET("/api/ws", wsHandler(llmService, modelValidator, maxPromptBytes, allowedOrigins))
+
+	// OpenAI-compatible chat completions
+	completionRoutes.POST("/v1/chat/completions", openAIChatHandler(llmService, modelValidator))
+
+	// Stream a completion as newline-delimited JSON for fetch-based clients
+	completionRoutes.POST("/api/complete/ndjson", ndjsonHandler(llmService, modelValidator, generationTimeout, auditLog, maxPromptBytes, charsPerToken))
+
+	// Define endpoint for streaming completion over Server-Sent Events
+	completionRoutes.POST("/api/stream", func(c *gin.Context) {
 		var req PromptRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondBindError(c, err)
+			return
+		}
+		if !checkStreamRequest(c, llmService, modelValidator, req, maxPromptBytes, charsPerToken) {
 			return
 		}
 
+		if isDryRun(c) {
+			c.JSON(http.StatusOK, llmService.buildOllamaRequest(req, true))
+			return
+		}
+
+		ctx := c.Request.Context()
 		startTime := time.Now()
-		response, err := llmService.GetCompletion(req.Prompt, req.Model)
+		streaming := false
+		startStream := func() {
+			if streaming {
+				return
+			}
+			streaming = true
+			c.Writer.Header().Set("Content-Type", "text/event-stream")
+			c.Writer.Header().Set("Cache-Control", "no-cache")
+			c.Writer.Header().Set("Connection", "keep-alive")
+		}
+
+		// Keep proxies from closing the connection while the model loads or
+		// evaluates the prompt, until the first token arrives
+		heartbeat := startSSEHeartbeat(c, sseHeartbeatInterval, startStream)
+		defer heartbeat.Stop()
+
+		// Tell the client where it stands while it waits for a free slot
+		queuedCtx := WithQueueObserver(ctx, func(position int, estimatedWait time.Duration) {
+			heartbeat.write(func() {
+				startStream()
+				c.SSEvent("queued", QueuePosition{Position: position, EstimatedWait: estimatedWaitString(estimatedWait)})
+				c.Writer.Flush()
+			})
+		})
+
+		// Bound the generation by its adaptive deadline, if any
+		genCtx, cancelDeadline, _ := generationTimeout.withDeadline(queuedCtx, llmService.buildOllamaRequest(req, true))
+		defer cancelDeadline()
+
+		var streamed strings.Builder
+		err := llmService.GetCompletionStream(genCtx, req, func(token string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			heartbeat.Stop()
+			streamed.WriteString(token)
+			startStream()
+			c.SSEvent("", StreamToken{Token: token})
+			c.Writer.Flush()
+			return nil
+		})
+		heartbeat.Stop()
+		if ctx.Err() != nil {
+			// Client went away, nothing left to send
+			return
+		}
+		truncatedByServer := errors.Is(err, ErrResponseTruncated)
+		stalled := errors.Is(err, ErrStreamStalled)
+		if err != nil && !truncatedByServer && !stalled {
+			if !streaming {
+				// Nothing has been sent yet so a regular error status still works
+				respondError(c, err)
+				return
+			}
+			c.SSEvent("error", newAPIError(c, errorCode(err), err.Error(), nil))
+			c.Writer.Flush()
+			return
+		}
+
+		streamReq := llmService.buildOllamaRequest(req, true)
+		auditLog.record(c, AuditRecord{
+			Model:    streamReq.Model,
+			Prompt:   streamReq.Prompt,
+			Suffix:   streamReq.Suffix,
+			System:   streamReq.System,
+			Response: streamed.String(),
+		})
+
+		startStream()
+		c.SSEvent("done", StreamDone{
+			Model:             req.Model,
+			Time:              time.Since(startTime).String(),
+			TruncatedByServer: truncatedByServer,
+			Stalled:           stalled,
+		})
+		c.Writer.Flush()
+	})
+
+	// List the models installed in Ollama
+	routes.GET("/api/models", func(c *gin.Context) {
+		models, err := llmService.ListModels(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"models": models, "aliases": llmService.Aliases()})
+	})
+
+	// List the models Ollama has loaded and the memory they take. Any of the
+	// PRELOAD_MODELS that aren't loaded are listed too, to confirm warm-up.
+	routes.GET("/api/models/running", func(c *gin.Context) {
+		running, err := llmService.ListRunningModels(c.Request.Context())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, PromptResponse{
-			Response: response,
-			Model:    req.Model,
-			Time:     time.Since(startTime).String(),
+		preloaded := make([]string, len(preloadModelNames))
+		for i, model := range preloadModelNames {
+			preloaded[i] = llmService.ResolveModel(model)
+		}
+		c.JSON(http.StatusOK, gin.H{"models": running, "preloadMissing": notRunning(preloaded, running)})
+	})
+
+	// Debug endpoint exposing generation concurrency
+	routes.GET("/debug/concurrency", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"inFlight": llmService.limiter.InFlight(),
+			"queued":   llmService.limiter.Queued(),
+			"capacity": llmService.limiter.Capacity(),
 		})
 	})
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	// Prometheus metrics endpoint
+	routes.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Pull a model into Ollama, streaming download progress over SSE
+	routes.POST("/api/models/pull", func(c *gin.Context) {
+		var req PullRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, req.Name)
+
+		c.Writer.Header().Set("Content-Type", "text/event-stream")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.Header().Set("Connection", "keep-alive")
+
+		ctx := c.Request.Context()
+		err := llmService.PullModel(ctx, req.Name, func(status string, completed, total int64) {
+			c.SSEvent("progress", PullProgress{Status: status, Completed: completed, Total: total})
+			c.Writer.Flush()
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.SSEvent("error", newAPIError(c, errorCode(err), err.Error(), nil))
+			c.Writer.Flush()
+			return
+		}
+
+		modelValidator.Invalidate()
+		c.SSEvent("done", gin.H{"model": req.Name})
+		c.Writer.Flush()
+	})
+
+	// Load a model into memory ahead of real traffic
+	routes.POST("/api/models/preload", func(c *gin.Context) {
+		var req PreloadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+		if !validateModel(c, modelValidator, req.Model) {
+			return
+		}
+
+		startTime := time.Now()
+		if err := llmService.PreloadModel(c.Request.Context(), req.Model, req.KeepAlive); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"model": llmService.ResolveModel(req.Model),
+			"time":  time.Since(startTime).String(),
+		})
+	})
+
+	// Free models from memory, or remove them from disk. Both affect every
+	// client of the Ollama instance, so they are only offered when API_TOKEN
+	// guards them.
+	if len(apiTokens) > 0 {
+		// Unload a model from memory, leaving it installed
+		routes.POST("/api/models/unload", func(c *gin.Context) {
+			var req UnloadModelRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				respondBindError(c, err)
+				return
+			}
+			c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+
+			if err := llmService.UnloadModel(c.Request.Context(), req.Model); err != nil {
+				respondError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"model": llmService.ResolveModel(req.Model), "unloaded": true, "status": "unloaded from memory, still installed"})
+		})
+
+		// Delete an installed model from disk. The name is matched as a
+		// catch-all since namespaced models like library/llama2 contain a slash.
+		routes.DELETE("/api/models/*name", func(c *gin.Context) {
+			name := strings.TrimPrefix(c.Param("name"), "/")
+			if name == "" {
+				respondErrorCode(c, CodeBadRequest, "model name is required", nil)
+				return
+			}
+			c.Set(modelContextKey, name)
+
+			if err := llmService.DeleteModel(c.Request.Context(), name); err != nil {
+				respondError(c, err)
+				return
+			}
+			modelValidator.Invalidate()
+			c.JSON(http.StatusOK, gin.H{"model": name, "deleted": true, "status": "deleted from disk"})
+		})
+	}
+
+	// Report the service and Ollama versions
+	routes.GET("/api/version", versionHandler(llmService))
+
+	// Health check endpoint, reports unhealthy when Ollama can't be reached
+	// or the server is shutting down
+	routes.GET("/health", func(c *gin.Context) {
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		if err := llmService.Ping(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": err.Error(), "circuit": llmService.BreakerState()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "healthy", "circuit": llmService.BreakerState()})
+	})
+
+	// Liveness endpoint, only confirms the process is up
+	routes.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
 	})
 
+	// Readiness endpoint, only passes once the default model has answered a
+	// warm-up generation so traffic never waits on a cold start
+	readiness := &readinessProbe{llm: llmService, ttl: readinessTTL, timeout: readinessTimeout}
+	routes.GET("/readyz", readyHandler(readiness, &draining))
+
 	// Start the server
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Using Ollama at %s with default model %s", ollamaURL, defaultModel)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	// Serve TLS, and with it HTTP/2, when TLS_CERT_FILE and TLS_KEY_FILE are
+	// set, requiring client certificates signed by TLS_CLIENT_CA if given
+	useTLS := tlsCertFile != "" || tlsKeyFile != ""
+	if useTLS {
+		if server.TLSConfig, err = newTLSConfig(tlsCertFile, tlsKeyFile, tlsClientCA); err != nil {
+			slog.Error("invalid TLS configuration", "error", err)
+			os.Exit(1)
+		}
+	} else if tlsClientCA != "" {
+		slog.Warn("TLS_CLIENT_CA has no effect without TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		slog.Error("failed to start server", "error", err)
+		os.Exit(1)
+	}
+	go func() {
+		slog.Info("starting server", "version", version, "commit", commit, "port", port, "tls", useTLS, "mtls", useTLS && tlsClientCA != "", "base_path", basePath, "gin_mode", ginMode, "trusted_proxies", trustedProxies, "trust_all_proxies", !restrictProxies, "ollama_urls", ollamaURLs, "default_model", defaultModel)
+		serve := server.Serve
+		if useTLS {
+			// The certificate is already loaded into the TLS config
+			serve = func(listener net.Listener) error { return server.ServeTLS(listener, "", "") }
+		}
+		if err := serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Load the PRELOAD_MODELS in the background now that requests are
+	// accepted, keeping them loaded for PRELOAD_KEEP_ALIVE
+	preloadCtx, stopPreload := context.WithCancel(context.Background())
+	defer stopPreload()
+	if len(preloadModelNames) > 0 {
+		go preloadModels(preloadCtx, llmService, preloadModelNames, keepAliveValue(preloadKeepAlive))
+	}
+	if llmService.warmPool != nil {
+		slog.Info("warming worker pool", "size", warmPoolSize, "interval", warmPoolInterval.String(), "model", llmService.ResolveModel(""))
+		go llmService.warmPool.Run(preloadCtx, llmService, warmPoolInterval, keepAliveValue(preloadKeepAlive))
+	}
+
+	// Wait for a termination signal, then let in-flight requests finish
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	// Fail health checks and reject new requests, giving load balancers
+	// SHUTDOWN_DRAIN_DELAY to deregister the instance before the listener closes
+	draining.Store(true)
+	stopPreload()
+	pending := inFlight.Load()
+	slog.Info("shutting down", "in_flight", pending, "drain_delay", shutdownDrainDelay.String(), "grace_period", shutdownGracePeriod.String())
+	time.Sleep(shutdownDrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	shutdownErr := server.Shutdown(ctx)
+	if shutdownErr != nil {
+		slog.Warn("grace period expired, forcing close", "in_flight", inFlight.Load(), "error", shutdownErr)
+		server.Close()
+	}
+
+	// Save the day's token usage now that requests have stopped
+	if tokenBudget != nil {
+		if err := tokenBudget.Flush(); err != nil {
+			slog.Warn("failed to flush token budget", "path", tokenBudgetFile, "error", err)
+		}
+	}
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			slog.Warn("failed to flush audit log", "path", auditLogPath, "error", err)
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	if shutdownErr == nil {
+		slog.Info("server stopped", "drained", pending)
 	}
 }
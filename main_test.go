@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestService returns an LLMService pointed at url with retries turned off
+// so each test sees exactly one request
+func newTestService(t *testing.T, url string) *LLMService {
+	t.Helper()
+	return NewLLMService(LLMConfig{
+		OllamaURLs:    []string{url},
+		DefaultModel:  "llama2",
+		Timeout:       5 * time.Second,
+		MaxConcurrent: 1,
+		MaxQueueWait:  time.Second,
+	})
+}
+
+// newMockOllama starts a server standing in for Ollama. Every request body
+// sent to /api/generate is decoded into the returned channel before handler
+// runs.
+func newMockOllama(t *testing.T, handler http.HandlerFunc) (*httptest.Server, <-chan OllamaRequest) {
+	t.Helper()
+	requests := make(chan OllamaRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/generate" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		requests <- req
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestGetCompletionSuccess(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OllamaResponse{
+			Model:     "mistral",
+			Response:  "Hello there",
+			Done:      true,
+			EvalCount: 2,
+		})
+	})
+	svc := newTestService(t, server.URL)
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "Say hello", Model: "mistral"})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if resp.Response != "Hello there" || !resp.Done || resp.Model != "mistral" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	sent := <-requests
+	if sent.Model != "mistral" || sent.Prompt != "Say hello" || sent.Stream {
+		t.Errorf("unexpected request body: model=%q prompt=%q stream=%v", sent.Model, sent.Prompt, sent.Stream)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	tests := []struct {
+		name string
+		resp OllamaResponse
+		want string
+	}{
+		{"natural end", OllamaResponse{Done: true, DoneReason: "stop"}, finishStop},
+		{"token limit", OllamaResponse{Done: true, DoneReason: "length"}, finishLength},
+		{"stop sequence", OllamaResponse{Done: true, DoneReason: "stop", StoppedAtSequence: true}, finishStopSequence},
+		{"deadline", OllamaResponse{Response: "partial"}, finishTimeout},
+		{"response limit", OllamaResponse{TruncatedByServer: true}, finishResponseLimit},
+		{"stalled", OllamaResponse{Stalled: true}, finishStalled},
+		{"unknown", OllamaResponse{Done: true, DoneReason: "other"}, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := finishReason(&tt.resp); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCompletionCutsAtStopSequence(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "mistral", Response: "one, two, END three", Done: true, DoneReason: "stop"})
+	})
+	svc := newTestService(t, server.URL)
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "count", Stop: []string{"END"}})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	<-requests
+	if resp.Response != "one, two, " || finishReason(resp) != finishStopSequence {
+		t.Errorf("expected the response cut at the stop sequence, got %q finishing with %q", resp.Response, finishReason(resp))
+	}
+}
+
+func TestGetCompletionSendsSuffix(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "codellama:code", Response: "return a + b", Done: true})
+	})
+	svc := newTestService(t, server.URL)
+
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{
+		Prompt: "def add(a, b):\n    ",
+		Suffix: "\n\nprint(add(1, 2))",
+		Model:  "codellama:code",
+	})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if sent := <-requests; sent.Prompt != "def add(a, b):\n    " || sent.Suffix != "\n\nprint(add(1, 2))" {
+		t.Errorf("expected prompt and suffix in the payload, got prompt=%q suffix=%q", sent.Prompt, sent.Suffix)
+	}
+}
+
+func TestGetCompletionSendsFormatSchema(t *testing.T) {
+	schema := `{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "mistral", Response: `{"name":"Ada"}`, Done: true})
+	})
+	svc := newTestService(t, server.URL)
+
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "who wrote the first program?", Format: json.RawMessage(schema)})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if sent := <-requests; string(sent.Format) != schema {
+		t.Errorf("expected the schema forwarded as format, got %s", sent.Format)
+	}
+}
+
+func TestGetCompletionWrapsPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PromptRequest
+		want string
+	}{
+		{name: "wrapped", req: PromptRequest{Prompt: "hi", System: "be nice"}, want: "Be brief. hi (in English)"},
+		{name: "skipped", req: PromptRequest{Prompt: "hi", SkipPromptWrap: true}, want: "hi"},
+		{name: "raw", req: PromptRequest{Prompt: "[INST] hi [/INST]", Raw: true}, want: "[INST] hi [/INST]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(OllamaResponse{Response: "Hello", Done: true})
+			})
+			svc := newTestService(t, server.URL)
+			svc.promptPrefix, svc.promptSuffix = "Be brief. ", " (in English)"
+
+			if _, err := svc.GetCompletion(context.Background(), tt.req); err != nil {
+				t.Fatalf("GetCompletion returned error: %v", err)
+			}
+			if sent := <-requests; sent.Prompt != tt.want || sent.System != tt.req.System {
+				t.Errorf("expected prompt %q with system %q, got %q with %q", tt.want, tt.req.System, sent.Prompt, sent.System)
+			}
+		})
+	}
+}
+
+func TestBuildOllamaRequestDefaultSystem(t *testing.T) {
+	svc := newTestService(t, "http://localhost")
+	svc.defaultSystem = "You are helpful."
+
+	tests := []struct {
+		name string
+		req  PromptRequest
+		want string
+	}{
+		{name: "default", req: PromptRequest{Prompt: "hi"}, want: "You are helpful."},
+		{name: "overridden", req: PromptRequest{Prompt: "hi", System: "You are a pirate."}, want: "You are a pirate."},
+		{name: "disabled", req: PromptRequest{Prompt: "hi", System: noSystemPrompt}, want: ""},
+		{name: "raw", req: PromptRequest{Prompt: "hi", Raw: true}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := svc.buildOllamaRequest(tt.req, false).System; got != tt.want {
+				t.Errorf("expected system %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCompletionUsesDefaultModel(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "ok", Done: true})
+	})
+	svc := newTestService(t, server.URL)
+
+	if _, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"}); err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if sent := <-requests; sent.Model != "llama2" {
+		t.Errorf("expected default model llama2, got %q", sent.Model)
+	}
+}
+
+func TestGetCompletionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    error
+		status  int
+		code    ErrorCode
+	}{
+		{
+			name: "model not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			},
+			want:   ErrModelNotFound,
+			status: http.StatusNotFound,
+			code:   CodeModelNotFound,
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"out of memory"}`, http.StatusInternalServerError)
+			},
+			want:   ErrOllamaUnavailable,
+			status: http.StatusServiceUnavailable,
+			code:   CodeOllamaUnavailable,
+		},
+		{
+			name: "bad request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"invalid options"}`, http.StatusBadRequest)
+			},
+			want:   ErrBadRequest,
+			status: http.StatusBadRequest,
+			code:   CodeBadRequest,
+		},
+		{
+			name: "generation error in body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(OllamaResponse{Error: "out of memory"})
+			},
+			want:   ErrGenerationFailed,
+			status: http.StatusInternalServerError,
+			code:   CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newMockOllama(t, tt.handler)
+			svc := newTestService(t, server.URL)
+
+			_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected error wrapping %v, got %v", tt.want, err)
+			}
+			if status := errorStatus(err); status != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, status)
+			}
+			if code := errorCode(err); code != tt.code {
+				t.Errorf("expected code %s, got %s", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestGetCompletionMalformedJSON(t *testing.T) {
+	server, _ := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response": "truncated`))
+	})
+	svc := newTestService(t, server.URL)
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if err == nil {
+		t.Fatalf("expected decode error, got response %+v", resp)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected a JSON decode error, got %v", err)
+	}
+}
+
+func TestGetCompletionConnectionRefused(t *testing.T) {
+	// Grab a free address, then close the server so nothing listens on it
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	svc := newTestService(t, url)
+
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if !errors.Is(err, ErrOllamaUnavailable) {
+		t.Fatalf("expected ErrOllamaUnavailable, got %v", err)
+	}
+	if status := errorStatus(err); status != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", status)
+	}
+}
+
+func TestGetCompletionContextCancelled(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		// Hold the request open until the client gives up
+		<-r.Context().Done()
+	})
+	svc := newTestService(t, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requests
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := svc.GetCompletion(ctx, PromptRequest{Prompt: "hi"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetCompletion took %s to notice cancellation", elapsed)
+	}
+}
+
+func TestGetCompletionTimeout(t *testing.T) {
+	server, _ := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	svc := newTestService(t, server.URL)
+	svc.timeout = 50 * time.Millisecond
+
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestGetCompletionRetryOnEmpty(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []string
+		want      string
+		err       error
+	}{
+		{name: "answers on retry", responses: []string{" \n", "Hello"}, want: "Hello"},
+		{name: "still empty", responses: []string{"", ""}, err: ErrEmptyResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(OllamaResponse{Response: tt.responses[calls], Done: true})
+				calls++
+			})
+			prompts := make(chan string, 2)
+			go func() {
+				for req := range requests {
+					prompts <- req.Prompt
+				}
+			}()
+			svc := newTestService(t, server.URL)
+			svc.retryOnEmpty = true
+
+			resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && resp.Response != tt.want {
+				t.Errorf("expected response %q, got %q", tt.want, resp.Response)
+			}
+			if calls != 2 {
+				t.Fatalf("expected one retry, got %d requests", calls)
+			}
+			if first, retry := <-prompts, <-prompts; first != "hi" || retry != "hi"+emptyResponseNudge {
+				t.Errorf("expected the retry to nudge the prompt, got %q then %q", first, retry)
+			}
+		})
+	}
+}
+
+func TestGetCompletionMaxResponseBytes(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		for _, token := range []string{"héllo ", "wörld ", "and ", "more"} {
+			json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: token})
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Done: true})
+	})
+	svc := newTestService(t, server.URL)
+	svc.maxResponseBytes = 9
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if !(<-requests).Stream {
+		t.Error("expected the capped completion to be streamed from Ollama")
+	}
+	// The cut falls inside ö, which is dropped whole
+	if resp.Response != "héllo w" || !resp.TruncatedByServer || resp.Done {
+		t.Errorf("expected a response cut at 9 bytes, got %q truncatedByServer=%v done=%v", resp.Response, resp.TruncatedByServer, resp.Done)
+	}
+}
+
+func TestGetCompletionMaxResponseBytesTimeout(t *testing.T) {
+	server, _ := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "partial "})
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	svc := newTestService(t, server.URL)
+	svc.maxResponseBytes = 100
+	svc.timeout = 100 * time.Millisecond
+
+	// Streaming only because of the cap, the request didn't ask for a
+	// partial response
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestGetCompletionModelLoading(t *testing.T) {
+	tests := []struct {
+		name string
+		wait time.Duration
+		err  error
+	}{
+		{name: "waits for the load", wait: 5 * time.Second},
+		{name: "fails straight away", err: ErrModelLoading},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.Header().Set("Retry-After", "7")
+					http.Error(w, `{"error":"loading model"}`, http.StatusServiceUnavailable)
+					return
+				}
+				json.NewEncoder(w).Encode(OllamaResponse{Response: "Hello", Done: true})
+			})
+			go func() {
+				for range requests {
+				}
+			}()
+			svc := newTestService(t, server.URL)
+			svc.modelLoadWait = tt.wait
+
+			resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil {
+				if resp.Response != "Hello" || calls != 2 {
+					t.Errorf("expected the answer once loaded, got %q after %d requests", resp.Response, calls)
+				}
+				return
+			}
+
+			var loadingErr *ModelLoadingError
+			if !errors.As(err, &loadingErr) || loadingErr.retryAfterSeconds() != "7" {
+				t.Errorf("expected Ollama's Retry-After to be passed on, got %v", err)
+			}
+			if status := errorStatus(err); status != http.StatusServiceUnavailable {
+				t.Errorf("expected status 503, got %d", status)
+			}
+			if calls != 1 {
+				t.Errorf("expected no retries while loading, got %d requests", calls)
+			}
+		})
+	}
+}
+
+func TestGetCompletionStreamStalled(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "Hello "})
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "there"})
+		w.(http.Flusher).Flush()
+		// Hang without finishing until the service gives up on the stream
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	go func() {
+		for range requests {
+		}
+	}()
+	svc := newTestService(t, server.URL)
+	svc.streamIdleTimeout = 100 * time.Millisecond
+
+	var streamed strings.Builder
+	err := svc.GetCompletionStream(context.Background(), PromptRequest{Prompt: "hi"}, func(token string) error {
+		streamed.WriteString(token)
+		return nil
+	})
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Fatalf("expected ErrStreamStalled, got %v", err)
+	}
+	if streamed.String() != "Hello there" {
+		t.Errorf("expected the tokens before the stall to be delivered, got %q", streamed.String())
+	}
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi", PartialOnTimeout: true})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if resp.Response != "Hello there" || !resp.Stalled || resp.Done {
+		t.Errorf("expected the partial response marked stalled, got %q stalled=%v done=%v", resp.Response, resp.Stalled, resp.Done)
+	}
+}
+
+func TestListRunningModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ps" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"models":[{"name":"llama2:latest","size":5137025024,"size_vram":4137025024,"expires_at":"2030-01-01T00:00:00Z"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	svc := newTestService(t, server.URL)
+
+	running, err := svc.ListRunningModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListRunningModels returned error: %v", err)
+	}
+	if len(running) != 1 || running[0].SizeVRAM != 4137025024 || running[0].ExpiresAt.Year() != 2030 {
+		t.Fatalf("unexpected running models: %+v", running)
+	}
+	if missing := notRunning([]string{"llama2", "mistral"}, running); len(missing) != 1 || missing[0] != "mistral" {
+		t.Errorf("expected only mistral to be missing, got %v", missing)
+	}
+}
+
+func TestValidateOllamaURL(t *testing.T) {
+	for _, valid := range []string{"http://localhost:11434", "https://ollama.internal"} {
+		if err := validateOllamaURL(valid); err != nil {
+			t.Errorf("expected %q to be valid, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"localhost:11434", "ftp://ollama", "http://", "http://bad host"} {
+		if err := validateOllamaURL(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestUnloadAndDeleteModel(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+		if r.Method == http.MethodDelete && !strings.Contains(string(body), "llama2") {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	svc := newTestService(t, server.URL)
+
+	if err := svc.UnloadModel(context.Background(), "llama2"); err != nil {
+		t.Fatalf("UnloadModel returned error: %v", err)
+	}
+	if err := svc.DeleteModel(context.Background(), "llama2"); err != nil {
+		t.Fatalf("DeleteModel returned error: %v", err)
+	}
+	if err := svc.DeleteModel(context.Background(), "mistral"); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("expected ErrModelNotFound deleting a missing model, got %v", err)
+	}
+
+	want := []string{
+		`POST /api/generate {"model":"llama2","prompt":"","stream":false,"keep_alive":0}`,
+		`DELETE /api/delete {"model":"llama2"}`,
+		`DELETE /api/delete {"model":"mistral"}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected requests\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(requestIDContextKey, "abc123")
+
+	respondError(c, &UnknownModelError{Model: "lama2", Suggestions: []string{"llama2"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+	want := `{"error":{"code":"MODEL_NOT_FOUND","message":"model \"lama2\" is not installed, did you mean llama2?","requestId":"abc123"}}`
+	if w.Body.String() != want {
+		t.Errorf("expected %s, got %s", want, w.Body.String())
+	}
+
+	for code := range errorCodeStatus {
+		if code.Status() < 400 {
+			t.Errorf("code %s has non-error status %d", code, code.Status())
+		}
+	}
+}
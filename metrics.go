@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// modelContextKey is the gin context key handlers use to report the model
+// they served so metrics can be labelled with it
+const modelContextKey = "model"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "homuncullm_http_requests_total",
+		Help: "HTTP requests handled, by route, model and status code.",
+	}, []string{"path", "model", "status"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "homuncullm_http_requests_in_flight",
+		Help: "HTTP requests currently being handled.",
+	})
+
+	ollamaRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "homuncullm_ollama_request_duration_seconds",
+		Help: "Duration of Ollama round trips, by endpoint and model.",
+		// Large models are slow, so span 100ms up to two minutes
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 90, 120},
+	}, []string{"endpoint", "model"})
+
+	ollamaTokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "homuncullm_ollama_tokens_total",
+		Help: "Tokens processed by Ollama, by model and type (prompt or completion).",
+	}, []string{"model", "type"})
+
+	deduplicatedRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "homuncullm_deduplicated_requests_total",
+		Help: "Completions answered by sharing an identical generation already in progress.",
+	})
+)
+
+// metricsMiddleware records request counts and in-flight requests for every route
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		c.Next()
+
+		path := c.FullPath()
+		if path == "" {
+			path = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(path, c.GetString(modelContextKey), strconv.Itoa(c.Writer.Status())).Inc()
+	}
+}
+
+// observeOllamaRequest records the duration of an Ollama round trip that began at start
+func observeOllamaRequest(endpoint string, model string, start time.Time) {
+	ollamaRequestDuration.WithLabelValues(endpoint, model).Observe(time.Since(start).Seconds())
+}
+
+// recordTokens adds a completion's token counts to the throughput counter and
+// to the span of the Ollama call ctx belongs to
+func recordTokens(ctx context.Context, model string, promptTokens int, completionTokens int) {
+	ollamaTokensTotal.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	ollamaTokensTotal.WithLabelValues(model, "completion").Add(float64(completionTokens))
+	ollamaSpan(ctx).SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", promptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", completionTokens),
+	)
+}
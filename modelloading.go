@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modelLoadPollInterval is how often a request waiting with MODEL_LOAD_WAIT
+// asks Ollama again whether the model has loaded
+const modelLoadPollInterval = 500 * time.Millisecond
+
+// ErrModelLoading is returned while Ollama is still loading the requested
+// model into memory and can't serve it yet
+var ErrModelLoading = errors.New("model is still loading")
+
+// modelLoadingMarkers are the phrases Ollama and its runner answer with, in
+// place of a response, while a model loads
+var modelLoadingMarkers = []string{"loading model", "model is loading", "model loading", "still loading"}
+
+// isModelLoading reports whether an error status from Ollama says the model
+// is still loading rather than that Ollama failed
+func isModelLoading(statusCode int, body string) bool {
+	if statusCode < 500 {
+		return false
+	}
+	body = strings.ToLower(body)
+	for _, marker := range modelLoadingMarkers {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelLoadingError is returned once Ollama reports the model is still
+// loading, carrying an estimate of how long the load has left
+type ModelLoadingError struct {
+	// RetryAfter is how long the client should wait before trying again
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ModelLoadingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ModelLoadingError) Unwrap() error {
+	return e.Err
+}
+
+// retryAfterSeconds is the Retry-After value for the load estimate, rounded
+// up to whole seconds
+func (e *ModelLoadingError) retryAfterSeconds() string {
+	seconds := int((e.RetryAfter + time.Second - 1) / time.Second)
+	return strconv.Itoa(max(seconds, 1))
+}
+
+// observeLoad records how long Ollama took to load a model. The longest load
+// seen stands in for how long a model still loading will take.
+func (s *LLMService) observeLoad(loadDuration int64) {
+	for {
+		longest := s.longestLoad.Load()
+		if loadDuration <= longest || s.longestLoad.CompareAndSwap(longest, loadDuration) {
+			return
+		}
+	}
+}
+
+// loadEstimate is how long a model that is still loading is expected to take,
+// preferring the Retry-After Ollama sent itself
+func (s *LLMService) loadEstimate(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+	if longest := time.Duration(s.longestLoad.Load()); longest > 0 {
+		return longest
+	}
+	return defaultModelLoadEstimate
+}
+
+// awaitModelLoad calls Ollama again every modelLoadPollInterval for as long as
+// it answers that the model is loading, up to MODEL_LOAD_WAIT. It returns the
+// first other outcome, or the last loading error once the wait is over.
+func (s *LLMService) awaitModelLoad(ctx context.Context, loadErr error, call func() (*http.Response, error)) (*http.Response, error) {
+	slog.InfoContext(ctx, "model is still loading, waiting for it", "maxWait", s.modelLoadWait)
+	waitCtx, cancel := context.WithTimeout(ctx, s.modelLoadWait)
+	defer cancel()
+
+	ticker := time.NewTicker(modelLoadPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, wrapRequestError(ctx.Err())
+			}
+			return nil, loadErr
+		case <-ticker.C:
+		}
+
+		resp, err := call()
+		if !errors.Is(err, ErrModelLoading) {
+			return resp, err
+		}
+		loadErr = err
+	}
+}
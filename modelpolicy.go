@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrModelNotAllowed is returned when a request names a model that
+// BLOCKED_MODELS or ALLOWED_MODELS rules out
+var ErrModelNotAllowed = errors.New("model is not allowed")
+
+// ModelPolicy restricts which models clients may use, so a shared machine
+// can't be made to load one too big for it. Patterns are globs where *
+// matches any run of characters, and a missing tag matches :latest.
+type ModelPolicy struct {
+	allowed []*regexp.Regexp
+	blocked []*regexp.Regexp
+}
+
+// NewModelPolicy rejects every model matching a blocked pattern and, when
+// allowed is not empty, every model matching none of its patterns
+func NewModelPolicy(allowed []string, blocked []string) *ModelPolicy {
+	return &ModelPolicy{allowed: compileModelGlobs(allowed), blocked: compileModelGlobs(blocked)}
+}
+
+// compileModelGlobs turns model name globs into anchored regexps
+func compileModelGlobs(globs []string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		quoted := regexp.QuoteMeta(strings.TrimSuffix(glob, ":latest"))
+		quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+		quoted = strings.ReplaceAll(quoted, `\?`, ".")
+		patterns = append(patterns, regexp.MustCompile("^"+quoted+"$"))
+	}
+	return patterns
+}
+
+// Check returns an error wrapping ErrModelNotAllowed when model is ruled out.
+// A nil policy allows every model.
+func (p *ModelPolicy) Check(model string) error {
+	if p == nil {
+		return nil
+	}
+	name := strings.TrimSuffix(model, ":latest")
+	for _, pattern := range p.blocked {
+		if pattern.MatchString(name) {
+			return fmt.Errorf("%w: %q is blocked on this server", ErrModelNotAllowed, model)
+		}
+	}
+	if len(p.allowed) == 0 {
+		return nil
+	}
+	for _, pattern := range p.allowed {
+		if pattern.MatchString(name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q is not in this server's allowed models", ErrModelNotAllowed, model)
+}
+
+// checkModel applies the model policy to the model a request names, once
+// aliases and the default are resolved
+func (s *LLMService) checkModel(model string) error {
+	return s.modelPolicy.Check(s.ResolveModel(model))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestModelPolicyCheck(t *testing.T) {
+	policy := NewModelPolicy([]string{"llama3*", "mistral"}, []string{"llama3:70b*"})
+	tests := []struct {
+		model   string
+		allowed bool
+	}{
+		{"llama3:8b", true},
+		{"llama3", true},
+		{"mistral:latest", true},
+		{"llama3:70b", false},
+		{"llama3:70b-instruct-q4_0", false},
+		{"mixtral:8x22b", false},
+	}
+	for _, tt := range tests {
+		err := policy.Check(tt.model)
+		if tt.allowed && err != nil {
+			t.Errorf("expected %s to be allowed, got %v", tt.model, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrModelNotAllowed) {
+			t.Errorf("expected %s to be rejected, got %v", tt.model, err)
+		}
+	}
+
+	if err := NewModelPolicy(nil, nil).Check("anything"); err != nil {
+		t.Errorf("expected an empty policy to allow every model, got %v", err)
+	}
+}
+
+func TestGetCompletionBlockedModel(t *testing.T) {
+	svc := NewLLMService(LLMConfig{
+		OllamaURLs:    []string{"http://127.0.0.1:1"},
+		DefaultModel:  "llama3:70b",
+		MaxConcurrent: 1,
+		BlockedModels: []string{"*:70b"},
+	})
+	_, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if !errors.Is(err, ErrModelNotAllowed) || errorStatus(err) != http.StatusForbidden {
+		t.Errorf("expected the default model to be blocked with 403, got %v", err)
+	}
+}
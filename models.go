@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelInfo describes a model installed in Ollama
+type ModelInfo struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OllamaTagsResponse represents the response from Ollama's tags API
+type OllamaTagsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+// ListModels returns the models installed in Ollama
+func (s *LLMService) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	resp, err := s.get(ctx, "/api/tags")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tagsResp OllamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama tags response: %w", err)
+	}
+
+	return tagsResp.Models, nil
+}
+
+// RunningModel describes a model Ollama has loaded into memory
+type RunningModel struct {
+	Name string `json:"name"`
+	// Size is the memory the loaded model takes, SizeVRAM how much of it is
+	// on the GPU
+	Size     int64 `json:"size"`
+	SizeVRAM int64 `json:"size_vram"`
+	// ExpiresAt is when Ollama unloads the model unless it is used again
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OllamaPsResponse represents the response from Ollama's ps API
+type OllamaPsResponse struct {
+	Models []RunningModel `json:"models"`
+}
+
+// ListRunningModels returns the models Ollama currently has loaded
+func (s *LLMService) ListRunningModels(ctx context.Context) ([]RunningModel, error) {
+	resp, err := s.get(ctx, "/api/ps")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var psResp OllamaPsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&psResp); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama ps response: %w", err)
+	}
+
+	return psResp.Models, nil
+}
+
+// notRunning returns the models that aren't among the running ones
+func notRunning(models []string, running []RunningModel) []string {
+	missing := []string{}
+	for _, model := range models {
+		loaded := false
+		for _, r := range running {
+			if modelNameMatches(r.Name, model) {
+				loaded = true
+				break
+			}
+		}
+		if !loaded {
+			missing = append(missing, model)
+		}
+	}
+	return missing
+}
+
+// parseModelAliases parses MODEL_ALIASES, a JSON object mapping alias names
+// to Ollama models
+func parseModelAliases(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var aliases map[string]string
+	if err := json.Unmarshal([]byte(value), &aliases); err != nil {
+		return nil, fmt.Errorf("MODEL_ALIASES must be a JSON object of alias to model: %w", err)
+	}
+	for alias, model := range aliases {
+		if alias == "" || model == "" {
+			return nil, fmt.Errorf("MODEL_ALIASES has an empty alias or model")
+		}
+	}
+	return aliases, nil
+}
+
+// UnknownModelError is returned when a request names a model that isn't installed
+type UnknownModelError struct {
+	Model       string
+	Suggestions []string
+}
+
+func (e *UnknownModelError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("model %q is not installed", e.Model)
+	}
+	return fmt.Sprintf("model %q is not installed, did you mean %s?", e.Model, strings.Join(e.Suggestions, ", "))
+}
+
+func (e *UnknownModelError) Unwrap() error {
+	return ErrModelNotFound
+}
+
+// maxModelSuggestions caps how many close matches an UnknownModelError lists
+const maxModelSuggestions = 3
+
+// ModelValidator checks requested model names against the installed models.
+// The model list is cached for ttl so validation doesn't cost a round trip to
+// Ollama on every request.
+type ModelValidator struct {
+	llm *LLMService
+	ttl time.Duration
+
+	mu        sync.Mutex
+	names     []string
+	fetchedAt time.Time
+}
+
+// NewModelValidator creates a validator backed by the given service
+func NewModelValidator(llm *LLMService, ttl time.Duration) *ModelValidator {
+	return &ModelValidator{llm: llm, ttl: ttl}
+}
+
+// Validate returns an error wrapping ErrModelNotAllowed when the model policy
+// rules model out, before asking Ollama anything, and an *UnknownModelError
+// when model isn't installed. An empty model is checked as the service's
+// default model. If the installed models can't be listed, validation is
+// skipped and the request is left to fail on its own.
+func (v *ModelValidator) Validate(ctx context.Context, model string) error {
+	if err := v.llm.checkModel(model); err != nil {
+		return err
+	}
+	model = v.llm.ResolveModel(model)
+
+	names, err := v.installed(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "skipping model validation", "error", err)
+		return nil
+	}
+
+	for _, name := range names {
+		if modelNameMatches(name, model) {
+			return nil
+		}
+	}
+
+	return &UnknownModelError{Model: model, Suggestions: closestModels(model, names)}
+}
+
+// installed returns the cached model names, refreshing them once the ttl expires
+func (v *ModelValidator) installed(ctx context.Context) ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.names != nil && time.Since(v.fetchedAt) < v.ttl {
+		return v.names, nil
+	}
+
+	models, err := v.llm.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(models))
+	for _, m := range models {
+		names = append(names, m.Name)
+	}
+	v.names = names
+	v.fetchedAt = time.Now()
+	return names, nil
+}
+
+// modelNameMatches reports whether a requested model refers to an installed
+// name, treating a missing tag as ":latest" like Ollama does
+func modelNameMatches(installed string, requested string) bool {
+	return installed == requested || installed == requested+":latest"
+}
+
+// closestModels returns the installed names nearest to model by edit distance
+func closestModels(model string, names []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	maxDistance := len(model) / 2
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	var candidates []candidate
+	for _, name := range names {
+		d := levenshtein(model, strings.TrimSuffix(name, ":latest"))
+		if full := levenshtein(model, name); full < d {
+			d = full
+		}
+		if d <= maxDistance {
+			candidates = append(candidates, candidate{name: name, distance: d})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxModelSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+	return suggestions
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// PullRequest is our API's model pull request structure
+type PullRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// PullProgress is a progress event sent while a model downloads
+type PullProgress struct {
+	Status    string `json:"status"`
+	Completed int64  `json:"completed,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+}
+
+// OllamaPullRequest represents the request structure for Ollama's pull API
+type OllamaPullRequest struct {
+	Model  string `json:"model"`
+	Name   string `json:"name"`
+	Stream bool   `json:"stream"`
+}
+
+// OllamaPullResponse is a single progress line from Ollama's pull API. Layer
+// downloads report their digest in the status, e.g. "pulling 8934d96d3f08".
+type OllamaPullResponse struct {
+	Status    string `json:"status"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// PullModel downloads a model into Ollama, invoking onProgress for every
+// progress update. A model that is already present completes almost
+// immediately with a "success" status.
+func (s *LLMService) PullModel(ctx context.Context, name string, onProgress func(status string, completed, total int64)) error {
+	resp, err := s.post(ctx, "/api/pull", OllamaPullRequest{
+		Model:  name,
+		Name:   name,
+		Stream: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readStream(ctx, resp.Body, func(line []byte) (bool, error) {
+		var progress OllamaPullResponse
+		if err := json.Unmarshal(line, &progress); err != nil {
+			return false, fmt.Errorf("failed to decode ollama pull progress: %w", err)
+		}
+		if progress.Error != "" {
+			return false, fmt.Errorf("ollama pull failed: %s", progress.Error)
+		}
+
+		onProgress(progress.Status, progress.Completed, progress.Total)
+		return progress.Status == "success", nil
+	})
+}
+
+// Invalidate drops the cached model list so the next validation refetches it
+func (v *ModelValidator) Invalidate() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.names = nil
+}
+
+// PreloadRequest is our API's model preload request structure
+type PreloadRequest struct {
+	Model string `json:"model"`
+	// KeepAlive defaults to -1, keeping the model loaded until Ollama restarts
+	KeepAlive json.RawMessage `json:"keepAlive"`
+}
+
+// PreloadModel loads a model into memory by sending an empty generation, and
+// keeps it resident for keepAlive (forever when empty)
+func (s *LLMService) PreloadModel(ctx context.Context, model string, keepAlive json.RawMessage) error {
+	if err := s.checkModel(model); err != nil {
+		return err
+	}
+	if len(keepAlive) == 0 {
+		keepAlive = json.RawMessage("-1")
+	}
+
+	resp, err := s.post(ctx, "/api/generate", OllamaRequest{
+		Model:     s.ResolveModel(model),
+		KeepAlive: keepAlive,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UnloadModelRequest is our API's model unload request structure
+type UnloadModelRequest struct {
+	Model string `json:"model" binding:"required"`
+}
+
+// UnloadModel frees the memory a loaded model holds by sending an empty
+// generation with a zero keep_alive. The model stays installed and loads
+// again on its next request.
+func (s *LLMService) UnloadModel(ctx context.Context, model string) error {
+	resp, err := s.post(ctx, "/api/generate", OllamaRequest{
+		Model:     s.ResolveModel(model),
+		KeepAlive: json.RawMessage("0"),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// OllamaDeleteRequest represents the request structure for Ollama's delete API
+type OllamaDeleteRequest struct {
+	Model string `json:"model"`
+}
+
+// DeleteModel removes an installed model from Ollama's disk. The name is
+// taken as given, never as an alias, since the delete can't be undone.
+func (s *LLMService) DeleteModel(ctx context.Context, name string) error {
+	reqBody, err := json.Marshal(OllamaDeleteRequest{Model: name})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	resp, err := s.do(ctx, http.MethodDelete, "/api/delete", reqBody)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// keepAliveValue converts a keep_alive setting into the JSON Ollama expects:
+// plain numbers are seconds, anything else is sent as a duration string
+func keepAliveValue(v string) json.RawMessage {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return json.RawMessage(v)
+	}
+	quoted, _ := json.Marshal(v)
+	return quoted
+}
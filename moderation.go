@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Moderator screens prompts before they reach the model and responses before
+// they reach the client
+type Moderator interface {
+	// CheckPrompt returns a *ModerationError when text must not be sent
+	CheckPrompt(ctx context.Context, text string) error
+	// FilterResponse returns text with anything flagged redacted or replaced
+	FilterResponse(ctx context.Context, text string) (string, error)
+}
+
+// ModerationError is returned when a prompt matches a blocking rule
+type ModerationError struct {
+	Category string
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("prompt blocked by moderation rule: %s", e.Category)
+}
+
+// noopModerator lets everything through, used when no rules are configured
+type noopModerator struct{}
+
+func (noopModerator) CheckPrompt(context.Context, string) error { return nil }
+
+func (noopModerator) FilterResponse(_ context.Context, text string) (string, error) {
+	return text, nil
+}
+
+// ModerationRule matches text by regular expression or by keyword, ignoring
+// case for keywords
+type ModerationRule struct {
+	Category string   `json:"category"`
+	Pattern  string   `json:"pattern"`
+	Keywords []string `json:"keywords"`
+	// AppliesTo is "prompt", "response" or "both", the default
+	AppliesTo string `json:"appliesTo"`
+	// Action on a flagged response is "redact", the default, which replaces
+	// each match with Replacement, or "replace" which replaces the whole
+	// response with it
+	Action      string `json:"action"`
+	Replacement string `json:"replacement"`
+}
+
+// moderationFile is the structure of the file named by MODERATION_RULES_FILE
+type moderationFile struct {
+	Rules []ModerationRule `json:"rules"`
+}
+
+// compiledRule is a ModerationRule with its matcher built
+type compiledRule struct {
+	ModerationRule
+	re *regexp.Regexp
+}
+
+// RuleModerator blocks prompts and filters responses matching its rules
+type RuleModerator struct {
+	rules []compiledRule
+}
+
+// LoadModerationRules reads the moderation rules file at path
+func LoadModerationRules(path string) (*RuleModerator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation rules: %w", err)
+	}
+
+	var file moderationFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse moderation rules %s: %w", path, err)
+	}
+
+	m := &RuleModerator{}
+	for i, rule := range file.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation rule %d in %s: %w", i, path, err)
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+// compileRule validates rule and builds a single regexp for its pattern and
+// keywords
+func compileRule(rule ModerationRule) (compiledRule, error) {
+	if rule.Category == "" {
+		return compiledRule{}, fmt.Errorf("category is required")
+	}
+	switch rule.AppliesTo {
+	case "":
+		rule.AppliesTo = "both"
+	case "prompt", "response", "both":
+	default:
+		return compiledRule{}, fmt.Errorf("appliesTo must be prompt, response or both")
+	}
+	switch rule.Action {
+	case "":
+		rule.Action = "redact"
+	case "redact", "replace":
+	default:
+		return compiledRule{}, fmt.Errorf("action must be redact or replace")
+	}
+	if rule.Replacement == "" {
+		rule.Replacement = "[REDACTED]"
+	}
+
+	var alternatives []string
+	if rule.Pattern != "" {
+		alternatives = append(alternatives, "(?:"+rule.Pattern+")")
+	}
+	for _, kw := range rule.Keywords {
+		alternatives = append(alternatives, "(?i:"+regexp.QuoteMeta(kw)+")")
+	}
+	if len(alternatives) == 0 {
+		return compiledRule{}, fmt.Errorf("pattern or keywords is required")
+	}
+
+	re, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return compiledRule{}, err
+	}
+	return compiledRule{ModerationRule: rule, re: re}, nil
+}
+
+// CheckPrompt blocks text matching any prompt rule
+func (m *RuleModerator) CheckPrompt(_ context.Context, text string) error {
+	for _, rule := range m.rules {
+		if rule.AppliesTo != "response" && rule.re.MatchString(text) {
+			return &ModerationError{Category: rule.Category}
+		}
+	}
+	return nil
+}
+
+// FilterResponse redacts or replaces text matching any response rule
+func (m *RuleModerator) FilterResponse(_ context.Context, text string) (string, error) {
+	for _, rule := range m.rules {
+		if rule.AppliesTo == "prompt" || !rule.re.MatchString(text) {
+			continue
+		}
+		if rule.Action == "replace" {
+			return rule.Replacement, nil
+		}
+		text = rule.re.ReplaceAllLiteralString(text, rule.Replacement)
+	}
+	return text, nil
+}
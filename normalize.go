@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Rules NORMALIZE_PROMPTS can apply to the text clients send
+const (
+	// normalizeLineEndings turns \r\n and lone \r into \n
+	normalizeLineEndings = "lineEndings"
+	// normalizeControls strips control characters other than newlines and
+	// tabs
+	normalizeControls = "controls"
+	// normalizeZeroWidth strips zero-width spaces, joiners and byte order
+	// marks
+	normalizeZeroWidth = "zeroWidth"
+	// normalizeNFC normalizes Unicode to NFC, so composed and decomposed
+	// accents look the same to the model
+	normalizeNFC = "nfc"
+	// normalizeWhitespace collapses runs of spaces and tabs, drops trailing
+	// spaces and keeps at most one blank line in a row
+	normalizeWhitespace = "whitespace"
+)
+
+// normalizeRules are every rule, in the order they are applied
+var normalizeRules = []string{normalizeLineEndings, normalizeControls, normalizeZeroWidth, normalizeNFC, normalizeWhitespace}
+
+// defaultNormalizeRules are applied when NORMALIZE_PROMPTS is true. Collapsing
+// whitespace is left out since it changes code and tables.
+var defaultNormalizeRules = []string{normalizeLineEndings, normalizeControls, normalizeZeroWidth, normalizeNFC}
+
+var (
+	horizontalSpaceRun = regexp.MustCompile(`[ \t]+`)
+	trailingSpace      = regexp.MustCompile(` +\n`)
+	blankLineRun       = regexp.MustCompile(`\n{3,}`)
+)
+
+// PromptNormalizer cleans up the prompts, suffixes and system prompts clients
+// send before they reach Ollama, with the rules NORMALIZE_PROMPTS turns on
+type PromptNormalizer struct {
+	rules map[string]bool
+}
+
+// NewPromptNormalizer applies rules, which must be names of the normalize
+// rules. It returns nil, normalizing nothing, when rules is empty.
+func NewPromptNormalizer(rules []string) (*PromptNormalizer, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	n := &PromptNormalizer{rules: make(map[string]bool, len(rules))}
+	for _, rule := range rules {
+		if !slices.Contains(normalizeRules, rule) {
+			return nil, fmt.Errorf("unknown rule %q, expected one of %s", rule, strings.Join(normalizeRules, ", "))
+		}
+		n.rules[rule] = true
+	}
+	return n, nil
+}
+
+// parseNormalizeRules reads NORMALIZE_PROMPTS, which is either true for the
+// default rules or the rules to apply, comma-separated
+func parseNormalizeRules(value string) []string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "false", "0":
+		return nil
+	case "true", "1":
+		return defaultNormalizeRules
+	}
+	var rules []string
+	for _, rule := range strings.Split(value, ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Normalize applies the rules to text. A nil normalizer returns it as is.
+func (n *PromptNormalizer) Normalize(text string) string {
+	if n == nil || text == "" {
+		return text
+	}
+	if n.rules[normalizeLineEndings] {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\r", "\n")
+	}
+	if n.rules[normalizeControls] || n.rules[normalizeZeroWidth] {
+		text = strings.Map(func(r rune) rune {
+			if n.rules[normalizeControls] && unicode.IsControl(r) && r != '\n' && r != '\t' {
+				return -1
+			}
+			if n.rules[normalizeZeroWidth] && isZeroWidth(r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	if n.rules[normalizeNFC] {
+		text = norm.NFC.String(text)
+	}
+	if n.rules[normalizeWhitespace] {
+		text = horizontalSpaceRun.ReplaceAllString(text, " ")
+		text = trailingSpace.ReplaceAllString(text, "\n")
+		text = blankLineRun.ReplaceAllString(text, "\n\n")
+	}
+	return text
+}
+
+// isZeroWidth reports whether r is an invisible character that takes no
+// space, such as a zero-width space or a byte order mark
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+		return true
+	}
+	return false
+}
+
+// normalizeMessages applies the normalizer to every message's content, leaving
+// messages itself untouched
+func (s *LLMService) normalizeMessages(messages []Message) []Message {
+	if s.normalizer == nil {
+		return messages
+	}
+	normalized := make([]Message, len(messages))
+	for i, message := range messages {
+		message.Content = s.normalizer.Normalize(message.Content)
+		normalized[i] = message
+	}
+	return normalized
+}
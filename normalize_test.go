@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPromptNormalizer(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		text  string
+		want  string
+	}{
+		{"line endings", []string{normalizeLineEndings}, "a\r\nb\rc", "a\nb\nc"},
+		{"controls", []string{normalizeControls}, "a\x00b\x1b[0m\tc\n", "ab[0m\tc\n"},
+		{"zero width", []string{normalizeZeroWidth}, "\ufeffzero\u200bwidth", "zerowidth"},
+		{"nfc", []string{normalizeNFC}, "cafe\u0301", "caf\u00e9"},
+		{"whitespace", []string{normalizeWhitespace}, "a  \t b   \n\n\n\nc", "a b\n\nc"},
+		{"defaults keep whitespace", defaultNormalizeRules, "a  b\r\n\n\n\nc\x07", "a  b\n\n\n\nc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewPromptNormalizer(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Normalize(tt.text); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := NewPromptNormalizer([]string{"emoji"}); err == nil {
+		t.Error("expected an error for an unknown rule")
+	}
+	var disabled *PromptNormalizer
+	if got := disabled.Normalize("a\x00b"); got != "a\x00b" {
+		t.Errorf("expected a nil normalizer to leave text alone, got %q", got)
+	}
+}
+
+func TestParseNormalizeRules(t *testing.T) {
+	tests := map[string][]string{
+		"":                 nil,
+		"false":            nil,
+		"true":             defaultNormalizeRules,
+		"1":                defaultNormalizeRules,
+		"nfc, whitespace,": {normalizeNFC, normalizeWhitespace},
+		"controls":         {normalizeControls},
+	}
+	for value, want := range tests {
+		if got := parseNormalizeRules(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestBuildOllamaRequestNormalizesPrompt(t *testing.T) {
+	s := newTestService(t, "http://localhost")
+	s.normalizer, _ = NewPromptNormalizer(defaultNormalizeRules)
+	got := s.buildOllamaRequest(PromptRequest{Prompt: "cafe\u0301\x00\r\n", SkipPromptWrap: true}, false)
+	if got.Prompt != "caf\u00e9\n" {
+		t.Errorf("expected the normalized prompt, got %q", got.Prompt)
+	}
+}
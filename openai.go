@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAIChatRequest is the request body of OpenAI's chat completions API
+type OpenAIChatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages" binding:"required,min=1,dive"`
+	Temperature *float64  `json:"temperature"`
+	TopP        *float64  `json:"top_p"`
+	MaxTokens   *int      `json:"max_tokens"`
+	Seed        *int      `json:"seed"`
+	Stream      bool      `json:"stream"`
+}
+
+// OpenAIChatResponse is the response body of OpenAI's chat completions API,
+// also used for streamed chunks
+type OpenAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+// OpenAIChoice is a single completion choice. Message is set on full
+// responses, Delta on streamed chunks.
+type OpenAIChoice struct {
+	Index        int          `json:"index"`
+	Message      *Message     `json:"message,omitempty"`
+	Delta        *OpenAIDelta `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// OpenAIDelta is the incremental message carried by a streamed chunk
+type OpenAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// OpenAIUsage reports token counts in OpenAI's format
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// toChatRequest translates an OpenAI request into our chat request
+func (r OpenAIChatRequest) toChatRequest() ChatRequest {
+	req := ChatRequest{
+		Messages: r.Messages,
+		Model:    r.Model,
+	}
+	if r.Temperature != nil || r.TopP != nil || r.MaxTokens != nil || r.Seed != nil {
+		req.Options = &GenerationOptions{
+			Temperature: r.Temperature,
+			TopP:        r.TopP,
+			NumPredict:  r.MaxTokens,
+			Seed:        r.Seed,
+		}
+	}
+	return req
+}
+
+// openAIError writes an error in the shape OpenAI clients expect
+func openAIError(c *gin.Context, status int, errType string, message string) {
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": errType}})
+}
+
+// openAIChatHandler serves POST /v1/chat/completions by translating to and
+// from the Ollama chat API
+func openAIChatHandler(llmService *LLMService, validator *ModelValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OpenAIChatRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			openAIError(c, status, "invalid_request_error", err.Error())
+			return
+		}
+		model := llmService.ResolveModel(req.Model)
+		c.Set(modelContextKey, model)
+
+		if err := validator.Validate(c.Request.Context(), req.Model); err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, ErrModelNotAllowed) {
+				status = http.StatusForbidden
+			}
+			openAIError(c, status, "invalid_request_error", err.Error())
+			return
+		}
+
+		id := "chatcmpl-" + newRequestID()
+		created := time.Now().Unix()
+
+		if req.Stream {
+			streamOpenAIChat(c, llmService, req.toChatRequest(), id, created, model)
+			return
+		}
+
+		result, err := llmService.GetChatCompletion(c.Request.Context(), req.toChatRequest())
+		if err != nil {
+			openAIError(c, errorStatus(err), "server_error", err.Error())
+			return
+		}
+
+		finish := "stop"
+		message := Message{Role: "assistant", Content: result.Message.Content}
+		c.JSON(http.StatusOK, OpenAIChatResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   model,
+			Choices: []OpenAIChoice{{Index: 0, Message: &message, FinishReason: &finish}},
+			Usage: &OpenAIUsage{
+				PromptTokens:     result.PromptEvalCount,
+				CompletionTokens: result.EvalCount,
+				TotalTokens:      result.PromptEvalCount + result.EvalCount,
+			},
+		})
+	}
+}
+
+// streamOpenAIChat streams a chat completion as OpenAI-style SSE chunks,
+// ending with data: [DONE]
+func streamOpenAIChat(c *gin.Context, llmService *LLMService, req ChatRequest, id string, created int64, model string) {
+	ctx := c.Request.Context()
+	chunk := func(delta OpenAIDelta, finish *string) OpenAIChatResponse {
+		return OpenAIChatResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []OpenAIChoice{{Index: 0, Delta: &delta, FinishReason: finish}},
+		}
+	}
+
+	streaming := false
+	err := llmService.GetChatCompletionStream(ctx, req, func(token string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !streaming {
+			streaming = true
+			c.Writer.Header().Set("Content-Type", "text/event-stream")
+			c.Writer.Header().Set("Cache-Control", "no-cache")
+			c.Writer.Header().Set("Connection", "keep-alive")
+			c.SSEvent("", chunk(OpenAIDelta{Role: "assistant"}, nil))
+		}
+		c.SSEvent("", chunk(OpenAIDelta{Content: token}, nil))
+		c.Writer.Flush()
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		if !streaming {
+			openAIError(c, errorStatus(err), "server_error", err.Error())
+			return
+		}
+		c.SSEvent("", gin.H{"error": gin.H{"message": err.Error(), "type": "server_error"}})
+		c.Writer.Flush()
+		return
+	}
+
+	if !streaming {
+		c.Writer.Header().Set("Content-Type", "text/event-stream")
+		c.SSEvent("", chunk(OpenAIDelta{Role: "assistant"}, nil))
+	}
+	finish := "stop"
+	c.SSEvent("", chunk(OpenAIDelta{}, &finish))
+	c.SSEvent("", "[DONE]")
+	c.Writer.Flush()
+}
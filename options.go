@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+)
+
+// OptionDefaults are the options applied to requests that don't set them.
+// Precedence is request options, then the model's defaults, then the global
+// defaults.
+type OptionDefaults struct {
+	Global *GenerationOptions
+	// Models maps a model name to its defaults, a name without a tag also
+	// matches the model's ":latest" tag
+	Models map[string]*GenerationOptions
+}
+
+// forModel returns the defaults configured for model, or nil
+func (d *OptionDefaults) forModel(model string) *GenerationOptions {
+	if opts, ok := d.Models[model]; ok {
+		return opts
+	}
+	for name, opts := range d.Models {
+		if modelNameMatches(name, model) || modelNameMatches(model, name) {
+			return opts
+		}
+	}
+	return nil
+}
+
+// resolveOptions merges the global and per-model defaults for model under the
+// request's own options
+func (s *LLMService) resolveOptions(model string, opts *GenerationOptions) *GenerationOptions {
+	defaults := s.defaults.Load()
+	if defaults == nil {
+		return mergeOptions(nil, opts)
+	}
+	return mergeOptions(mergeOptions(defaults.Global, defaults.forModel(model)), opts)
+}
+
+// mergeOptions returns base with every field set in override taking
+// precedence. Neither argument is modified, and nil is returned when both are
+// nil.
+func mergeOptions(base *GenerationOptions, override *GenerationOptions) *GenerationOptions {
+	if base == nil && override == nil {
+		return nil
+	}
+
+	merged := GenerationOptions{}
+	if base != nil {
+		merged = *base
+	}
+	if override == nil {
+		merged.Stop = append([]string(nil), merged.Stop...)
+		return &merged
+	}
+
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		merged.TopK = override.TopK
+	}
+	if override.NumPredict != nil {
+		merged.NumPredict = override.NumPredict
+	}
+	if override.NumCtx != nil {
+		merged.NumCtx = override.NumCtx
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	if len(override.Stop) > 0 {
+		merged.Stop = override.Stop
+	}
+	merged.Stop = append([]string(nil), merged.Stop...)
+	return &merged
+}
+
+// Validate reports options Ollama would reject or misbehave on
+func (o *GenerationOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Temperature != nil && *o.Temperature < 0 {
+		return errors.New("temperature must not be negative")
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		return errors.New("top_p must be between 0 and 1")
+	}
+	if o.TopK != nil && *o.TopK < 0 {
+		return errors.New("top_k must not be negative")
+	}
+	if o.NumCtx != nil && *o.NumCtx <= 0 {
+		return errors.New("num_ctx must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrResponseTruncated is returned by a stream cut off at MAX_RESPONSE_BYTES,
+// once the text up to the cap has been delivered
+var ErrResponseTruncated = errors.New("response exceeded the server's maximum length")
+
+// ErrStreamStalled is returned by a stream Ollama stopped sending tokens on
+// for STREAM_IDLE_TIMEOUT without finishing, once the tokens it did send have
+// been delivered
+var ErrStreamStalled = errors.New("ollama stopped sending tokens")
+
+// collectStream reads a streamed generation into a single response. With
+// partialOnTimeout set, if the deadline passes after some text was generated,
+// that text is returned with Done false instead of an error. With maxBytes
+// set, reading stops once the text reaches it, and the text, cut to maxBytes,
+// is returned with Done false and TruncatedByServer set. The caller closing
+// body then aborts the generation. A stream that stalls is returned as far as
+// it got with Done false and Stalled set.
+func collectStream(ctx context.Context, body io.Reader, maxBytes int, partialOnTimeout bool) (*OllamaResponse, error) {
+	var text strings.Builder
+	var final OllamaResponse
+	var logprobs []OllamaLogprob
+	chunks := 0
+
+	err := readStream(ctx, body, func(line []byte) (bool, error) {
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return false, fmt.Errorf("failed to decode ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return false, fmt.Errorf("%w: %s", ErrGenerationFailed, chunk.Error)
+		}
+
+		text.WriteString(chunk.Response)
+		logprobs = append(logprobs, chunk.Logprobs...)
+		chunks++
+		if chunk.Done {
+			final = chunk
+		} else if final.Model == "" {
+			final.Model = chunk.Model
+			final.CreatedAt = chunk.CreatedAt
+		}
+		if !chunk.Done && maxBytes > 0 && text.Len() >= maxBytes {
+			final.TruncatedByServer = true
+			return true, nil
+		}
+		return chunk.Done, nil
+	})
+	final.Stalled = errors.Is(err, ErrStreamStalled)
+	timedOut := partialOnTimeout && errors.Is(err, ErrTimeout) && text.Len() > 0
+	if err != nil && !final.Stalled && !timedOut {
+		return nil, err
+	}
+
+	final.Response = text.String()
+	final.Logprobs = logprobs
+	if final.TruncatedByServer {
+		final.Response = truncateUTF8(final.Response, maxBytes)
+	}
+	if final.TruncatedByServer || final.Stalled || timedOut {
+		// Ollama never sent its counts, but it streams a token per chunk
+		final.EvalCount = chunks
+	}
+	return &final, nil
+}
+
+// truncateUTF8 cuts text to at most n bytes without splitting a character
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !isRuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdleTTL is how long a client's limiter is kept after its last request
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// IPRateLimiter hands out a token bucket per client IP
+type IPRateLimiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewIPRateLimiter creates a limiter allowing rps requests per second per IP
+// with bursts of up to burst requests
+func NewIPRateLimiter(rps float64, burst int) *IPRateLimiter {
+	return &IPRateLimiter{
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve takes a token for ip and returns how long the caller would have to
+// wait for it. A zero delay means the request is allowed.
+func (l *IPRateLimiter) Reserve(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimiterIdleTTL {
+		l.sweep(now)
+	}
+
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	r := client.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return rateLimiterIdleTTL
+	}
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		// The request is rejected, so give the token back
+		r.CancelAt(now)
+	}
+	return delay
+}
+
+// sweep drops limiters for clients that have been idle, bounding memory use
+func (l *IPRateLimiter) sweep(now time.Time) {
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) > rateLimiterIdleTTL {
+			delete(l.clients, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitMiddleware rejects clients exceeding their rate with 429. Paths in
+// exempt are never limited.
+func rateLimitMiddleware(limiter *IPRateLimiter, exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		if delay := limiter.Reserve(c.ClientIP()); delay > 0 {
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			abortWithErrorCode(c, CodeRateLimited, "rate limit exceeded", gin.H{"retryAfter": retryAfter})
+			return
+		}
+		c.Next()
+	}
+}
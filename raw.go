@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RawResponse wraps Ollama's untouched generate response with our timing
+type RawResponse struct {
+	Result json.RawMessage `json:"result"`
+	Time   string          `json:"time"`
+}
+
+// GenerateRaw proxies a full Ollama generate request body, including fields
+// like context, raw, template and images, and returns Ollama's response as is
+func (s *LLMService) GenerateRaw(ctx context.Context, body map[string]json.RawMessage) (json.RawMessage, error) {
+	model := ""
+	json.Unmarshal(body["model"], &model)
+	if err := s.modelPolicy.Check(model); err != nil {
+		return nil, err
+	}
+
+	if err := s.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.limiter.Release()
+	ctx, done := s.warmPool.use(ctx)
+	defer done()
+	defer observeOllamaRequest("generate", model, time.Now())
+	ctx, span := startOllamaSpan(ctx, "generate", model)
+	defer span.End()
+
+	resp, err := s.post(ctx, "/api/generate", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, wrapRequestError(ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to read ollama response: %w", err)
+	}
+	if !json.Valid(data) {
+		return nil, errors.New("ollama returned invalid JSON")
+	}
+
+	var counts struct {
+		EvalCount int `json:"eval_count"`
+	}
+	json.Unmarshal(data, &counts)
+	meterTokens(ctx, counts.EvalCount)
+	return data, nil
+}
+
+// validateRawRequest checks the only fields the passthrough requires
+func validateRawRequest(body map[string]json.RawMessage) error {
+	for _, field := range []string{"model", "prompt"} {
+		var v string
+		if err := json.Unmarshal(body[field], &v); err != nil || v == "" {
+			return fmt.Errorf("%s is required and must be a string", field)
+		}
+	}
+
+	var stream bool
+	if raw, ok := body["stream"]; ok {
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return errors.New("stream must be a boolean")
+		}
+	}
+	if stream {
+		return errors.New("streaming is not supported on /api/raw, use /api/stream")
+	}
+	return nil
+}
+
+// rawHandler serves POST /api/raw, a low-level escape hatch exposing every
+// Ollama generate parameter
+func rawHandler(llmService *LLMService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body map[string]json.RawMessage
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		if err := validateRawRequest(body); err != nil {
+			respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+			return
+		}
+
+		var model string
+		json.Unmarshal(body["model"], &model)
+		c.Set(modelContextKey, model)
+
+		// Ollama streams by default, the passthrough always wants one object
+		body["stream"] = json.RawMessage("false")
+
+		startTime := time.Now()
+		result, err := llmService.GenerateRaw(c.Request.Context(), body)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, RawResponse{
+			Result: result,
+			Time:   time.Since(startTime).String(),
+		})
+	}
+}
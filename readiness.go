@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warmUpPrompt is the prompt of the generation readiness checks run
+const warmUpPrompt = "hi"
+
+// WarmUp runs a one token generation against the default model, loading it
+// if needed, so a success means the model can serve requests right away
+func (s *LLMService) WarmUp(ctx context.Context) error {
+	return s.WarmUpModel(ctx, "", s.keepAlive)
+}
+
+// WarmUpModel runs a one token generation against model, loading it if
+// needed and keeping it loaded for keepAlive afterwards
+func (s *LLMService) WarmUpModel(ctx context.Context, model string, keepAlive json.RawMessage) error {
+	numPredict := 1
+	resp, err := s.post(ctx, "/api/generate", OllamaRequest{
+		Model:     s.ResolveModel(model),
+		Prompt:    warmUpPrompt,
+		Options:   &GenerationOptions{NumPredict: &numPredict},
+		KeepAlive: keepAlive,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// readinessProbe caches the outcome of warm-up generations for ttl, so
+// frequent probes don't each cost a generation. Concurrent probes share one
+// warm-up.
+type readinessProbe struct {
+	llm     *LLMService
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// check returns the cached warm-up result, running a new warm-up once the
+// last one is older than ttl
+func (p *readinessProbe) check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checkedAt.IsZero() && time.Since(p.checkedAt) < p.ttl {
+		return p.err
+	}
+
+	warmCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	err := p.llm.WarmUp(warmCtx)
+	if err != nil && ctx.Err() != nil {
+		// A probe whose caller went away says nothing about the model
+		return err
+	}
+	p.checkedAt, p.err = time.Now(), err
+	return err
+}
+
+// readyHandler serves GET /readyz, answering 200 only once the default
+// model has completed a warm-up generation. It fails while draining.
+func readyHandler(probe *readinessProbe, draining *atomic.Bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		if err := probe.check(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "model": probe.llm.ResolveModel(""), "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "model": probe.llm.ResolveModel("")})
+	}
+}
+
+// preloadModels warms up each of models in turn, so they are resident before
+// real traffic arrives, logging how long each took to load. Failures are only
+// logged, a model that can't be preloaded still loads on first use.
+func preloadModels(ctx context.Context, llm *LLMService, models []string, keepAlive json.RawMessage) {
+	for _, model := range models {
+		start := time.Now()
+		if err := llm.WarmUpModel(ctx, model, keepAlive); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("failed to preload model", "model", llm.ResolveModel(model), "error", err)
+			continue
+		}
+		slog.Info("preloaded model", "model", llm.ResolveModel(model), "duration", time.Since(start).String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Completion response shapes a client can ask for with Accept-Version
+const (
+	// responseV1 is the original shape, with response and a duration string
+	// in time
+	responseV1 = "v1"
+	// responseV2 renames response to text and reports latency_ms as integer
+	// milliseconds instead of time
+	responseV2 = "v2"
+)
+
+// responseVersion is the shape sent when a request has no Accept-Version
+// header, injected at build time with -ldflags "-X main.responseVersion=v2"
+var responseVersion = responseV1
+
+// PromptResponseV2 is PromptResponse in the v2 shape. Embedding keeps every
+// other field in step with PromptResponse.
+type PromptResponseV2 struct {
+	Text      string `json:"text"`
+	LatencyMs int64  `json:"latency_ms"`
+	PromptResponse
+	// Response and Time are left empty, hiding the v1 fields of the same
+	// names
+	Response string `json:"response,omitempty"`
+	Time     string `json:"time,omitempty"`
+}
+
+// validResponseVersion reports whether version names a response shape
+func validResponseVersion(version string) bool {
+	return version == responseV1 || version == responseV2
+}
+
+// negotiateResponseVersion returns the response shape the request asked for
+// with Accept-Version, or the build's default. A bare number like "2" is
+// accepted too.
+func negotiateResponseVersion(c *gin.Context) (string, error) {
+	version := strings.ToLower(strings.TrimSpace(c.GetHeader("Accept-Version")))
+	if version == "" {
+		return responseVersion, nil
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !validResponseVersion(version) {
+		return "", fmt.Errorf("unsupported Accept-Version %q, expected %s or %s", c.GetHeader("Accept-Version"), responseV1, responseV2)
+	}
+	return version, nil
+}
+
+// writeResponse writes a completion response in the shape the request asked
+// for, which took elapsed to generate
+func writeResponse(c *gin.Context, resp PromptResponse, elapsed time.Duration) {
+	version, err := negotiateResponseVersion(c)
+	if err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return
+	}
+	c.Writer.Header().Add("Vary", "Accept-Version")
+	c.Header("Content-Version", version)
+	if version == responseV2 {
+		c.JSON(http.StatusOK, PromptResponseV2{
+			Text:           resp.Response,
+			LatencyMs:      elapsed.Milliseconds(),
+			PromptResponse: resp,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
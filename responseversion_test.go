@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWriteResponseV2(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/complete", nil)
+	c.Request.Header.Set("Accept-Version", "v2")
+
+	writeResponse(c, PromptResponse{Response: "hi", Model: "llama2", Time: "2.3s"}, 2300*time.Millisecond)
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body["text"] != "hi" || body["latency_ms"] != float64(2300) || body["model"] != "llama2" {
+		t.Errorf("unexpected v2 response %s", w.Body)
+	}
+	if _, ok := body["response"]; ok {
+		t.Errorf("expected no response field in v2, got %s", w.Body)
+	}
+	if _, ok := body["time"]; ok {
+		t.Errorf("expected no time field in v2, got %s", w.Body)
+	}
+}
+
+func TestNegotiateResponseVersion(t *testing.T) {
+	tests := map[string]string{"": responseV1, "v1": responseV1, "V2": responseV2, "2": responseV2, "v9": ""}
+	for header, want := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/api/complete", strings.NewReader(""))
+		c.Request.Header.Set("Accept-Version", header)
+
+		got, err := negotiateResponseVersion(c)
+		if (err != nil) != (want == "") || got != want {
+			t.Errorf("Accept-Version %q: expected %q, got %q (%v)", header, want, got, err)
+		}
+	}
+}
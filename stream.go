@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NDJSONChunk is one line of an NDJSON completion stream. The last line has
+// Done set along with the model and time, or Error when generation failed
+// part way through.
+type NDJSONChunk struct {
+	Token string `json:"token"`
+	Done  bool   `json:"done"`
+	Model string `json:"model,omitempty"`
+	Time  string `json:"time,omitempty"`
+	Error string `json:"error,omitempty"`
+	// TruncatedByServer is set on the last line when the stream was cut off
+	// at MAX_RESPONSE_BYTES
+	TruncatedByServer bool `json:"truncatedByServer,omitempty"`
+	// Stalled is set on the last line when the model stopped generating for
+	// STREAM_IDLE_TIMEOUT
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+// checkStreamRequest runs the checks shared by the streaming completion
+// endpoints, writing the error response when one fails. It reports whether
+// the request may proceed.
+func checkStreamRequest(c *gin.Context, llmService *LLMService, validator *ModelValidator, req PromptRequest, maxPromptBytes int, charsPerToken float64) bool {
+	c.Set(modelContextKey, llmService.ResolveModel(req.Model))
+	c.Set(promptLengthContextKey, len(req.Prompt)+len(req.Suffix))
+
+	if !checkPromptSize(c, len(req.Prompt)+len(req.Suffix), maxPromptBytes) {
+		return false
+	}
+
+	if err := validateFormat(req.Format); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return false
+	}
+
+	if err := validateImages(req.Images); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return false
+	}
+
+	if err := validateRaw(req); err != nil {
+		respondErrorCode(c, CodeBadRequest, err.Error(), nil)
+		return false
+	}
+
+	if req.N > 1 {
+		respondErrorCode(c, CodeBadRequest, "n greater than 1 is not supported when streaming", nil)
+		return false
+	}
+
+	if req.Translate {
+		respondErrorCode(c, CodeBadRequest, "translate is not supported when streaming", nil)
+		return false
+	}
+
+	if req.Logprobs {
+		respondErrorCode(c, CodeNotSupported, "logprobs are not supported when streaming", nil)
+		return false
+	}
+
+	if !checkContextWindow(c, llmService.buildOllamaRequest(req, true), charsPerToken) {
+		return false
+	}
+
+	return validateModel(c, validator, req.Model)
+}
+
+// ndjsonHandler serves POST /api/complete/ndjson, streaming the completion as
+// newline-delimited JSON in the style of Ollama's own stream, for clients
+// that read a fetch response incrementally rather than consume SSE
+func ndjsonHandler(llmService *LLMService, validator *ModelValidator, timeout adaptiveTimeout, auditLog *AuditLogger, maxPromptBytes int, charsPerToken float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PromptRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		if !checkStreamRequest(c, llmService, validator, req, maxPromptBytes, charsPerToken) {
+			return
+		}
+
+		if isDryRun(c) {
+			c.JSON(http.StatusOK, llmService.buildOllamaRequest(req, true))
+			return
+		}
+
+		ctx := c.Request.Context()
+		startTime := time.Now()
+		encoder := json.NewEncoder(c.Writer)
+		streaming := false
+		writeLine := func(chunk NDJSONChunk) error {
+			if !streaming {
+				streaming = true
+				c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+				c.Writer.Header().Set("Cache-Control", "no-cache")
+				c.Status(http.StatusOK)
+			}
+			if err := encoder.Encode(chunk); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+
+		// Bound the generation by its adaptive deadline, if any
+		ollamaReq := llmService.buildOllamaRequest(req, true)
+		genCtx, cancelDeadline, _ := timeout.withDeadline(ctx, ollamaReq)
+		defer cancelDeadline()
+
+		var streamed strings.Builder
+		err := llmService.GetCompletionStream(genCtx, req, func(token string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			streamed.WriteString(token)
+			return writeLine(NDJSONChunk{Token: token})
+		})
+		if ctx.Err() != nil {
+			// Client went away, nothing left to send
+			return
+		}
+		truncatedByServer := errors.Is(err, ErrResponseTruncated)
+		stalled := errors.Is(err, ErrStreamStalled)
+		if err != nil && !truncatedByServer && !stalled {
+			if !streaming {
+				// Nothing has been sent yet so a regular error status still works
+				respondError(c, err)
+				return
+			}
+			writeLine(NDJSONChunk{Done: true, Error: err.Error()})
+			return
+		}
+
+		auditLog.record(c, AuditRecord{
+			Model:    ollamaReq.Model,
+			Prompt:   ollamaReq.Prompt,
+			Suffix:   ollamaReq.Suffix,
+			System:   ollamaReq.System,
+			Response: streamed.String(),
+		})
+
+		writeLine(NDJSONChunk{
+			Done:              true,
+			Model:             req.Model,
+			Time:              time.Since(startTime).String(),
+			TruncatedByServer: truncatedByServer,
+			Stalled:           stalled,
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNDJSONStreamEndsWithOllamaError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/generate" {
+			// Leaves the validator unable to list models, so it lets the request through
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "Hello "})
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "there"})
+		w.Write([]byte(`{"error":"out of memory"}` + "\n"))
+	}))
+	t.Cleanup(server.Close)
+	svc := newTestService(t, server.URL)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/complete/ndjson", ndjsonHandler(svc, NewModelValidator(svc, time.Minute), adaptiveTimeout{}, nil, 0, 0))
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/complete/ndjson", strings.NewReader(`{"prompt":"hi"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var chunks []NDJSONChunk
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var chunk NDJSONChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("failed to decode line %q: %v", scanner.Text(), err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 3 || chunks[0].Token != "Hello " || chunks[1].Token != "there" {
+		t.Fatalf("expected both tokens then a final line, got %+v", chunks)
+	}
+	if last := chunks[2]; !last.Done || !strings.Contains(last.Error, "out of memory") {
+		t.Errorf("expected the stream to end with the ollama error, got %+v", last)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// summarizationContextKey is the gin.Context key holding the
+// SummarizationSteps of a request whose prompt was summarized
+const summarizationContextKey = "summarization"
+
+// summarizeInstruction is prepended to every chunk sent to be summarized
+const summarizeInstruction = "Summarize the following text. Keep every fact, figure, name and instruction needed to act on it, and leave out nothing a reader of the full text would need.\n\n"
+
+// summarizeConfig controls how oversized prompts are condensed with
+// autoSummarize
+type summarizeConfig struct {
+	// chunkTokens is the estimated size of each piece summarized on its own
+	chunkTokens int
+	// overlapTokens is repeated from the end of one chunk at the start of the
+	// next so nothing split across them is lost
+	overlapTokens int
+	// maxLevels bounds how many times summaries are summarized again when
+	// they still don't fit
+	maxLevels     int
+	charsPerToken float64
+}
+
+// SummarizationSteps reports the work done to fit an oversized prompt
+type SummarizationSteps struct {
+	// Levels is how many rounds of summarization ran
+	Levels int `json:"levels"`
+	// Chunks is how many chunk summaries were generated across all levels
+	Chunks int `json:"chunks"`
+	// OriginalTokens and FinalTokens estimate the prompt's size before and
+	// after summarizing
+	OriginalTokens int `json:"originalTokens"`
+	FinalTokens    int `json:"finalTokens"`
+}
+
+// SummarizeToFit condenses text until it is estimated to fit in budget
+// tokens, map-reduce style: the text is split into overlapping chunks, each
+// is summarized with req's model, and the summaries are joined. The result is
+// summarized again, up to cfg.maxLevels rounds, while it is still too long.
+func (s *LLMService) SummarizeToFit(ctx context.Context, req PromptRequest, text string, budget int, cfg summarizeConfig) (string, SummarizationSteps, error) {
+	steps := SummarizationSteps{OriginalTokens: estimateTokens(text, cfg.charsPerToken)}
+	chunkTokens := min(cfg.chunkTokens, budget-estimateTokens(summarizeInstruction, cfg.charsPerToken))
+	if chunkTokens <= cfg.overlapTokens {
+		return "", steps, fmt.Errorf("%w: context window too small to summarize the prompt", ErrBadRequest)
+	}
+
+	for estimateTokens(text, cfg.charsPerToken) > budget {
+		if steps.Levels == cfg.maxLevels {
+			return "", steps, fmt.Errorf("%w: prompt still exceeds the context window after %d levels of summarization", ErrBadRequest, steps.Levels)
+		}
+
+		chunks := splitChunks(text, int(float64(chunkTokens)*cfg.charsPerToken), int(float64(cfg.overlapTokens)*cfg.charsPerToken))
+		summaries, err := s.summarizeChunks(ctx, req, chunks)
+		if err != nil {
+			return "", steps, err
+		}
+		steps.Levels++
+		steps.Chunks += len(chunks)
+		text = strings.Join(summaries, "\n\n")
+	}
+
+	steps.FinalTokens = estimateTokens(text, cfg.charsPerToken)
+	return text, steps, nil
+}
+
+// summarizeChunks summarizes the chunks with as many workers as the limiter
+// has slots, so a long input never floods the queue. The first failure
+// cancels the rest.
+func (s *LLMService) summarizeChunks(ctx context.Context, req PromptRequest, chunks []string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	summaries := make([]string, len(chunks))
+	next := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for range min(len(chunks), s.limiter.Capacity()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				result, err := s.GetCompletion(ctx, PromptRequest{
+					Prompt:         summarizeInstruction + chunks[i],
+					Model:          req.Model,
+					Options:        req.Options,
+					SkipPromptWrap: true,
+				})
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("failed to summarize chunk %d: %w", i+1, err)
+						cancel()
+					})
+					continue
+				}
+				summaries[i] = strings.TrimSpace(result.Response)
+			}
+		}()
+	}
+
+feed:
+	for i := range chunks {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return summaries, nil
+}
+
+// splitChunks splits text into pieces of at most size bytes, each starting
+// overlap bytes before the end of the previous one. Pieces end at whitespace
+// where possible and never split a UTF-8 character.
+func splitChunks(text string, size int, overlap int) []string {
+	var chunks []string
+	for start := 0; start < len(text); {
+		end := start + size
+		if end >= len(text) {
+			chunks = append(chunks, text[start:])
+			break
+		}
+		for end > start && !isRuneStart(text[end]) {
+			end--
+		}
+		if end == start {
+			// size is smaller than the character, take it whole
+			end = start + 1
+			for end < len(text) && !isRuneStart(text[end]) {
+				end++
+			}
+		}
+		// Prefer a word boundary in the second half of the chunk
+		if i := strings.LastIndexFunc(text[start:end], unicode.IsSpace); i > size/2 {
+			end = start + i + 1
+		}
+		chunks = append(chunks, text[start:end])
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		} else if i := strings.IndexFunc(text[next:end], unicode.IsSpace); i >= 0 {
+			// Start the overlap on a whole word
+			next += i + 1
+		}
+		for next < len(text) && !isRuneStart(text[next]) {
+			next++
+		}
+		start = next
+	}
+	return chunks
+}
+
+// isRuneStart reports whether b begins a UTF-8 encoded character
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitChunks(t *testing.T) {
+	var words []string
+	for i := range 100 {
+		words = append(words, fmt.Sprintf("wörd%d", i))
+	}
+	text := strings.Join(words, " ")
+	chunks := splitChunks(text, 40, 10)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > 40 {
+			t.Errorf("chunk %d is %d bytes, want at most 40", i, len(chunk))
+		}
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %d splits a character: %q", i, chunk)
+		}
+	}
+
+	// Every word of the input appears in some chunk, in order
+	var covered strings.Builder
+	for i, chunk := range chunks {
+		if i == 0 {
+			covered.WriteString(chunk)
+			continue
+		}
+		// Drop the overlap repeated from the previous chunk
+		prev := covered.String()
+		overlap := 0
+		for n := min(len(chunk), len(prev)); n > 0; n-- {
+			if strings.HasSuffix(prev, chunk[:n]) {
+				overlap = n
+				break
+			}
+		}
+		covered.WriteString(chunk[overlap:])
+	}
+	if covered.String() != text {
+		t.Errorf("chunks don't cover the input:\n%q\n%q", covered.String(), text)
+	}
+}
+
+func TestSplitChunksShortText(t *testing.T) {
+	chunks := splitChunks("short", 40, 10)
+	if len(chunks) != 1 || chunks[0] != "short" {
+		t.Errorf("expected one chunk holding the whole text, got %q", chunks)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// promptTemplateExt is the file extension of prompt templates in TEMPLATES_DIR
+const promptTemplateExt = ".tmpl"
+
+// ErrUnknownTemplate is returned when a request names a template that isn't loaded
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// TemplateRequest completes a named prompt template rendered with Vars
+type TemplateRequest struct {
+	Template string             `json:"template" binding:"required"`
+	Vars     map[string]any     `json:"vars"`
+	Model    string             `json:"model"`
+	Options  *GenerationOptions `json:"options"`
+}
+
+// PromptTemplates holds the named text/template prompt templates
+type PromptTemplates struct {
+	templates map[string]*template.Template
+}
+
+// LoadPromptTemplates parses every .tmpl file in dir, naming each template
+// after its file name without the extension
+func LoadPromptTemplates(dir string) (*PromptTemplates, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+promptTemplateExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	t := &PromptTemplates{templates: make(map[string]*template.Template, len(paths))}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), promptTemplateExt)
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", name, err)
+		}
+		t.templates[name] = tmpl
+	}
+	return t, nil
+}
+
+// Len returns the number of loaded templates
+func (t *PromptTemplates) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.templates)
+}
+
+// Render executes the named template with vars. A variable the template uses
+// but vars doesn't set is an error.
+func (t *PromptTemplates) Render(name string, vars map[string]any) (string, error) {
+	var tmpl *template.Template
+	if t != nil {
+		tmpl = t.templates[name]
+	}
+	if tmpl == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	// Editors leave a trailing newline at the end of template files
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultThinkingTags are used when THINKING_TAGS is unset
+var defaultThinkingTags = []string{"think", "thinking", "reasoning"}
+
+// ThinkingStripper removes the reasoning blocks, such as <think>...</think>,
+// that reasoning models wrap around their chain of thought
+type ThinkingStripper struct {
+	tags []string
+}
+
+// NewThinkingStripper creates a stripper for the given tag names
+func NewThinkingStripper(tags []string) *ThinkingStripper {
+	return &ThinkingStripper{tags: tags}
+}
+
+// Strip returns text without its reasoning blocks, and the reasoning that was
+// removed. An unclosed opening tag runs to the end of the text, as when
+// generation stopped mid-thought, and a closing tag with no opening tag
+// covers everything before it, as some models omit the opening tag.
+func (t *ThinkingStripper) Strip(text string) (response string, thinking string) {
+	var parts []string
+	for _, tag := range t.tags {
+		open, close := "<"+tag+">", "</"+tag+">"
+
+		if ci := strings.Index(text, close); ci >= 0 {
+			if oi := strings.Index(text, open); oi < 0 || oi > ci {
+				parts = append(parts, text[:ci])
+				text = text[ci+len(close):]
+			}
+		}
+
+		for {
+			oi := strings.Index(text, open)
+			if oi < 0 {
+				break
+			}
+			rest := text[oi+len(open):]
+			ci := strings.Index(rest, close)
+			if ci < 0 {
+				parts = append(parts, rest)
+				text = text[:oi]
+				break
+			}
+			parts = append(parts, rest[:ci])
+			text = text[:oi] + rest[ci+len(close):]
+		}
+	}
+
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.TrimSpace(text), strings.TrimSpace(strings.Join(parts, "\n"))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeoutContextKey is the gin.Context key holding a request's adaptive
+// deadline, or the one it asked for with X-Max-Wait
+const timeoutContextKey = "timeout"
+
+// maxWaitHeader is the request header a client sets its own deadline with,
+// as a duration such as 10s
+const maxWaitHeader = "X-Max-Wait"
+
+// clientDeadlineKey is the context.Context key holding the deadline a client
+// asked for with X-Max-Wait
+type clientDeadlineKey struct{}
+
+// adaptiveTimeout sizes each generation's deadline to its prompt, since a
+// long prompt takes far longer to evaluate than a one-liner
+type adaptiveTimeout struct {
+	// base is the deadline of an empty prompt, zero turning adaptive
+	// deadlines off
+	base time.Duration
+	// perToken is added for every estimated prompt token
+	perToken time.Duration
+	// max caps the deadline, however long the prompt
+	max           time.Duration
+	charsPerToken float64
+}
+
+// forRequest returns the deadline for req, or zero when adaptive deadlines
+// are off
+func (t adaptiveTimeout) forRequest(req OllamaRequest) time.Duration {
+	if t.base <= 0 {
+		return 0
+	}
+	d := t.base + time.Duration(estimateRequestTokens(req, t.charsPerToken))*t.perToken
+	if t.max > 0 && d > t.max {
+		return t.max
+	}
+	return d
+}
+
+// withDeadline bounds ctx by the deadline for req. The returned duration is
+// zero when adaptive deadlines are off and ctx is returned as is. A request
+// that set X-Max-Wait keeps the deadline it asked for instead.
+func (t adaptiveTimeout) withDeadline(ctx context.Context, req OllamaRequest) (context.Context, context.CancelFunc, time.Duration) {
+	if wait, ok := ctx.Value(clientDeadlineKey{}).(time.Duration); ok {
+		// maxWaitMiddleware already bounds ctx by it
+		return ctx, func() {}, wait
+	}
+	d := t.forRequest(req)
+	if d == 0 {
+		return ctx, func() {}, 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, d
+}
+
+// maxWaitMiddleware bounds each request that sets X-Max-Wait by the deadline
+// it asks for, capped at limit, so an interactive client can give up early
+// and a batch job can wait longer than the adaptive deadline. Requests
+// without the header are left to the adaptive deadline.
+func maxWaitMiddleware(limit time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(maxWaitHeader)
+		if value == "" {
+			c.Next()
+			return
+		}
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait <= 0 {
+			abortWithErrorCode(c, CodeBadRequest, "X-Max-Wait must be a positive duration such as 30s", nil)
+			return
+		}
+		if limit > 0 && wait > limit {
+			wait = limit
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+		c.Request = c.Request.WithContext(context.WithValue(ctx, clientDeadlineKey{}, wait))
+		c.Set(timeoutContextKey, wait)
+		c.Next()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxWaitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(maxWaitMiddleware(time.Minute))
+	timeout := adaptiveTimeout{base: time.Hour}
+	router.GET("/", func(c *gin.Context) {
+		ctx, cancel, d := timeout.withDeadline(c.Request.Context(), OllamaRequest{})
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		c.JSON(http.StatusOK, gin.H{"timeout": d.String(), "remaining": time.Until(deadline).Round(time.Minute).String()})
+	})
+
+	tests := []struct {
+		header string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, `{"remaining":"1h0m0s","timeout":"1h0m0s"}`},
+		{"10s", http.StatusOK, `{"remaining":"0s","timeout":"10s"}`},
+		{"2h", http.StatusOK, `{"remaining":"1m0s","timeout":"1m0s"}`},
+		{"soon", http.StatusBadRequest, ""},
+		{"-5s", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(maxWaitHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.want != "" && w.Body.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, w.Body.String())
+			}
+		})
+	}
+}
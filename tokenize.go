@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tokenizers POST /api/tokenize estimates with, set by TOKENIZER or per
+// request
+const (
+	// tokenizerAuto uses tokenizerBPE for the models in bpeModelFamilies and
+	// tokenizerChars for the rest
+	tokenizerAuto = "auto"
+	// tokenizerChars divides the length by CHARS_PER_TOKEN, the same estimate
+	// the context window checks use
+	tokenizerChars = "chars"
+	// tokenizerBPE splits text the way tiktoken-style tokenizers do before
+	// merging, giving token boundaries as well as a count
+	tokenizerBPE = "bpe"
+)
+
+// bpeWholeWordRunes is the longest word, leading space included, counted as a
+// single token by tokenizerBPE. Common words are whole tokens in a
+// tiktoken-style vocabulary, longer ones split into pieces.
+const bpeWholeWordRunes = 7
+
+// bpeModelFamilies are the models with tiktoken-style vocabularies, for which
+// tokenizerAuto uses tokenizerBPE
+var bpeModelFamilies = []string{"llama3", "qwen2", "qwen3", "gpt-oss", "phi4", "deepseek"}
+
+// TokenizeRequest is our API's tokenize request structure
+type TokenizeRequest struct {
+	Prompt string `json:"prompt" binding:"required"`
+	Model  string `json:"model"`
+	// Tokenizer overrides the server's TOKENIZER for this request
+	Tokenizer string `json:"tokenizer"`
+}
+
+// TokenizeResponse is our API's tokenize response structure
+type TokenizeResponse struct {
+	Model     string `json:"model"`
+	Tokens    int    `json:"tokens"`
+	Tokenizer string `json:"tokenizer"`
+	// Estimate is set when the count is an approximation rather than the
+	// model's own tokenization, which Ollama doesn't expose
+	Estimate bool `json:"estimate"`
+	// Boundaries are the estimated tokens, when the tokenizer can tell them
+	Boundaries []TokenBoundary `json:"boundaries,omitempty"`
+}
+
+// TokenBoundary is one estimated token, Start and End being byte offsets into
+// the prompt
+type TokenBoundary struct {
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// validTokenizer reports whether name is a tokenizer /api/tokenize knows
+func validTokenizer(name string) bool {
+	return name == tokenizerAuto || name == tokenizerChars || name == tokenizerBPE
+}
+
+// resolveTokenizer picks the tokenizer used for model when auto is asked for
+func resolveTokenizer(name string, model string) string {
+	if name != tokenizerAuto {
+		return name
+	}
+	family := strings.ToLower(model)
+	for _, prefix := range bpeModelFamilies {
+		if strings.HasPrefix(family, prefix) {
+			return tokenizerBPE
+		}
+	}
+	return tokenizerChars
+}
+
+// bpeTokens estimates the tokens of text by splitting it into words, digit
+// groups, punctuation and whitespace the way tiktoken-style tokenizers do,
+// then splitting long words into pieces of about charsPerToken characters
+func bpeTokens(text string, charsPerToken float64) []TokenBoundary {
+	pieceRunes := max(int(math.Round(charsPerToken)), 1)
+	var tokens []TokenBoundary
+	emit := func(start int, end int) {
+		if runes := utf8.RuneCountInString(text[start:end]); runes > bpeWholeWordRunes {
+			// Split long words into subword pieces
+			for i, n := start, 0; i < end; n = 0 {
+				j := i
+				for j < end && n < pieceRunes {
+					_, size := utf8.DecodeRuneInString(text[j:])
+					j += size
+					n++
+				}
+				tokens = append(tokens, TokenBoundary{Text: text[i:j], Start: i, End: j})
+				i = j
+			}
+			return
+		}
+		tokens = append(tokens, TokenBoundary{Text: text[start:end], Start: start, End: end})
+	}
+
+	for i := 0; i < len(text); {
+		start := i
+		r, size := utf8.DecodeRuneInString(text[i:])
+		// A single space joins the word or punctuation after it
+		if r == ' ' && i+size < len(text) {
+			if next, _ := utf8.DecodeRuneInString(text[i+size:]); !unicode.IsSpace(next) && !unicode.IsDigit(next) {
+				i += size
+				r, size = utf8.DecodeRuneInString(text[i:])
+			}
+		}
+
+		switch {
+		case isIdeograph(r):
+			// Each CJK character is usually a token of its own
+			i += size
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			i += size
+			for i < len(text) {
+				next, n := utf8.DecodeRuneInString(text[i:])
+				if isIdeograph(next) || !(unicode.IsLetter(next) || unicode.IsMark(next)) {
+					break
+				}
+				i += n
+			}
+		case unicode.IsDigit(r):
+			// Numbers are split into groups of up to three digits
+			i += size
+			for digits := 1; i < len(text) && digits < 3; digits++ {
+				next, n := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(next) {
+					break
+				}
+				i += n
+			}
+			emit(start, i)
+			continue
+		case unicode.IsSpace(r):
+			i += size
+			for i < len(text) {
+				next, n := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(next) {
+					break
+				}
+				i += n
+			}
+		default:
+			i += size
+			for i < len(text) {
+				next, n := utf8.DecodeRuneInString(text[i:])
+				if unicode.IsSpace(next) || unicode.IsLetter(next) || unicode.IsDigit(next) {
+					break
+				}
+				i += n
+			}
+		}
+		emit(start, i)
+	}
+	return tokens
+}
+
+// isIdeograph reports whether r is a CJK character, which tokenizers rarely
+// merge with its neighbours
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// tokenizeHandler serves POST /api/tokenize, estimating how many tokens a
+// prompt will use so clients can budget before sending it. The prompt is
+// counted as given, without PROMPT_PREFIX and PROMPT_SUFFIX.
+func tokenizeHandler(llmService *LLMService, tokenizer string, charsPerToken float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TokenizeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		model := llmService.ResolveModel(req.Model)
+		c.Set(modelContextKey, model)
+
+		name := tokenizer
+		if req.Tokenizer != "" {
+			name = strings.ToLower(req.Tokenizer)
+		}
+		if !validTokenizer(name) {
+			respondErrorCode(c, CodeBadRequest, fmt.Sprintf("unknown tokenizer %q, expected %s, %s or %s", req.Tokenizer, tokenizerAuto, tokenizerChars, tokenizerBPE), nil)
+			return
+		}
+
+		resp := TokenizeResponse{Model: req.Model, Tokenizer: resolveTokenizer(name, model), Estimate: true}
+		if resp.Tokenizer == tokenizerBPE {
+			resp.Boundaries = bpeTokens(req.Prompt, charsPerToken)
+			resp.Tokens = len(resp.Boundaries)
+		} else {
+			resp.Tokens = estimateTokens(req.Prompt, charsPerToken)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
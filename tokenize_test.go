@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBPETokens(t *testing.T) {
+	text := "Hello, world! Tokenization 12345\n\n日本"
+	tokens := bpeTokens(text, defaultCharsPerToken)
+
+	var texts []string
+	end := 0
+	for _, token := range tokens {
+		if token.Start != end || text[token.Start:token.End] != token.Text {
+			t.Fatalf("expected contiguous boundaries, got %+v after offset %d", token, end)
+		}
+		end = token.End
+		texts = append(texts, token.Text)
+	}
+	if end != len(text) {
+		t.Errorf("expected the boundaries to cover the text, ended at %d of %d", end, len(text))
+	}
+
+	want := "Hello|,| world|!| Tok|eniz|atio|n| |123|45|\n\n|日|本"
+	if got := strings.Join(texts, "|"); got != want {
+		t.Errorf("expected tokens %q, got %q", want, got)
+	}
+}
+
+func TestResolveTokenizer(t *testing.T) {
+	if got := resolveTokenizer(tokenizerAuto, "llama3.2:3b"); got != tokenizerBPE {
+		t.Errorf("expected bpe for llama3.2, got %s", got)
+	}
+	if got := resolveTokenizer(tokenizerAuto, "mistral"); got != tokenizerChars {
+		t.Errorf("expected chars for mistral, got %s", got)
+	}
+	if got := resolveTokenizer(tokenizerChars, "llama3.2"); got != tokenizerChars {
+		t.Errorf("expected an explicit tokenizer to be kept, got %s", got)
+	}
+}
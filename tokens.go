@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultCharsPerToken is used when CHARS_PER_TOKEN is unset or invalid. Four
+// characters per token is a rough average for English text with common
+// tokenizers; code and non-Latin scripts usually need fewer per token.
+const defaultCharsPerToken = 4.0
+
+// estimateTokens approximates how many tokens text encodes to. It is only a
+// heuristic, so it is used to catch prompts that clearly don't fit rather
+// than to enforce exact limits.
+func estimateTokens(text string, charsPerToken float64) int {
+	if charsPerToken <= 0 {
+		charsPerToken = defaultCharsPerToken
+	}
+	return int(math.Ceil(float64(len(text)) / charsPerToken))
+}
+
+// estimateRequestTokens approximates how many tokens of the context window a
+// generate request fills before the model replies
+func estimateRequestTokens(req OllamaRequest, charsPerToken float64) int {
+	return estimateTokens(req.System, charsPerToken) + estimateTokens(req.Prompt, charsPerToken) + estimateTokens(req.Suffix, charsPerToken) + len(req.Context)
+}
+
+// checkContextWindow rejects the request with 400 when its estimated token
+// count exceeds the requested num_ctx, which Ollama would otherwise silently
+// truncate. It reports whether the request may proceed.
+func checkContextWindow(c *gin.Context, req OllamaRequest, charsPerToken float64) bool {
+	if req.Options == nil || req.Options.NumCtx == nil {
+		return true
+	}
+
+	if *req.Options.NumCtx <= 0 {
+		respondErrorCode(c, CodeBadRequest, "numCtx must be positive", nil)
+		return false
+	}
+
+	estimated := estimateRequestTokens(req, charsPerToken)
+	if numCtx := *req.Options.NumCtx; estimated > numCtx {
+		respondErrorCode(c, CodeContextWindowExceeded, "prompt likely exceeds the context window, raise numCtx or shorten the prompt", gin.H{
+			"estimatedTokens": estimated,
+			"numCtx":          numCtx,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxToolResultBytes caps how much of a tool endpoint's response is passed
+// back to the model
+const maxToolResultBytes = 64 * 1024
+
+// ErrToolLoopLimit is returned when the model keeps calling tools past the
+// iteration cap instead of producing an answer
+var ErrToolLoopLimit = errors.New("tool call limit reached without a final answer")
+
+// Tool describes a function the model may call, in Ollama's format
+type Tool struct {
+	Type     string       `json:"type" binding:"required,eq=function"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, purpose and JSON schema of a tool's arguments
+type ToolFunction struct {
+	Name        string          `json:"name" binding:"required"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is a model's request to call one of the request's tools
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the tool called and holds its arguments as a JSON
+// object
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolHandler runs a tool with the model's arguments and returns the result
+// handed back to the model
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// ToolRegistry holds the tools the server runs itself, by name. Calls to any
+// other tool are returned to the client to run.
+type ToolRegistry map[string]ToolHandler
+
+// parseToolEndpoints parses TOOL_ENDPOINTS, a JSON object of tool name to the
+// URL its arguments are POSTed to
+func parseToolEndpoints(value string, client *http.Client) (ToolRegistry, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var endpoints map[string]string
+	if err := json.Unmarshal([]byte(value), &endpoints); err != nil {
+		return nil, fmt.Errorf("TOOL_ENDPOINTS must be a JSON object of tool name to URL: %w", err)
+	}
+	tools := make(ToolRegistry, len(endpoints))
+	for name, url := range endpoints {
+		if name == "" || url == "" {
+			return nil, fmt.Errorf("TOOL_ENDPOINTS has an empty tool name or URL")
+		}
+		tools[name] = httpToolHandler(client, url)
+	}
+	return tools, nil
+}
+
+// httpToolHandler runs a tool by POSTing its arguments to url, using the
+// response body as the result
+func httpToolHandler(client *http.Client, url string) ToolHandler {
+	return func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(arguments))
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResultBytes))
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return "", fmt.Errorf("tool endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return string(body), nil
+	}
+}
+
+// handlesAll reports whether the server can run every one of calls itself.
+// Only tools the request declared are run, whatever the model asks for.
+func (r ToolRegistry) handlesAll(calls []ToolCall, declared []Tool) bool {
+	for _, call := range calls {
+		if _, ok := r[call.Function.Name]; !ok {
+			return false
+		}
+		if !slices.ContainsFunc(declared, func(t Tool) bool { return t.Function.Name == call.Function.Name }) {
+			return false
+		}
+	}
+	return true
+}
+
+// run calls the tool and wraps its result in a tool message. A failing tool
+// reports the error to the model, which can often recover from it.
+func (r ToolRegistry) run(ctx context.Context, call ToolCall) Message {
+	arguments := call.Function.Arguments
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	result, err := r[call.Function.Name](ctx, arguments)
+	if err != nil {
+		slog.WarnContext(ctx, "tool call failed", "tool", call.Function.Name, "error", err)
+		result = "error: " + err.Error()
+	}
+	return Message{Role: "tool", Content: result, ToolName: call.Function.Name}
+}
+
+// GetChatCompletionWithTools runs a chat that may call tools. While the model
+// only calls tools in the registry, they are run and their results sent back
+// until it answers, for at most maxIterations rounds of tool calls. It
+// returns the final response and every message added to the conversation,
+// ending with the reply, whose tool calls are left for the client to run
+// when the server can't.
+func (s *LLMService) GetChatCompletionWithTools(ctx context.Context, req ChatRequest, tools ToolRegistry, maxIterations int) (*OllamaChatResponse, []Message, error) {
+	req.Messages = slices.Clip(req.Messages)
+	var trace []Message
+	for iteration := 0; ; iteration++ {
+		result, err := s.GetChatCompletion(ctx, req)
+		if err != nil {
+			return nil, trace, err
+		}
+		trace = append(trace, result.Message)
+
+		calls := result.Message.ToolCalls
+		if len(calls) == 0 || !tools.handlesAll(calls, req.Tools) {
+			return result, trace, nil
+		}
+		if iteration == maxIterations {
+			return nil, trace, fmt.Errorf("%w after %d rounds", ErrToolLoopLimit, maxIterations)
+		}
+
+		added := []Message{result.Message}
+		for _, call := range calls {
+			added = append(added, tools.run(ctx, call))
+		}
+		trace = append(trace, added[1:]...)
+		req.Messages = append(req.Messages, added...)
+	}
+}
+
+// toolTrace returns the trace to report in a chat response, which is only
+// worth sending when tools ran before the reply
+func toolTrace(trace []Message) []Message {
+	if len(trace) < 2 {
+		return nil
+	}
+	return trace
+}
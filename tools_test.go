@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newMockChat starts a server standing in for Ollama's chat API. It asks for
+// the weather tool until it has been given a tool result, then answers,
+// unless always is set.
+func newMockChat(t *testing.T, always bool) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		last := req.Messages[len(req.Messages)-1]
+		message := Message{Role: "assistant", Content: "it is " + last.Content}
+		if always || last.Role != "tool" {
+			message = Message{Role: "assistant", ToolCalls: []ToolCall{{Function: ToolCallFunction{
+				Name:      "weather",
+				Arguments: json.RawMessage(`{"city":"Paris"}`),
+			}}}}
+		}
+		json.NewEncoder(w).Encode(OllamaChatResponse{Message: message, Done: true})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+var weatherTool = Tool{Type: "function", Function: ToolFunction{Name: "weather"}}
+
+func TestGetChatCompletionWithTools(t *testing.T) {
+	svc := newTestService(t, newMockChat(t, false).URL)
+	tools := ToolRegistry{"weather": func(_ context.Context, arguments json.RawMessage) (string, error) {
+		if string(arguments) != `{"city":"Paris"}` {
+			t.Errorf("unexpected arguments %s", arguments)
+		}
+		return "sunny", nil
+	}}
+
+	result, trace, err := svc.GetChatCompletionWithTools(context.Background(), ChatRequest{
+		Messages: []Message{{Role: "user", Content: "weather?"}},
+		Tools:    []Tool{weatherTool},
+	}, tools, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Message.Content != "it is sunny" {
+		t.Errorf("expected the answer to use the tool result, got %q", result.Message.Content)
+	}
+	if len(trace) != 3 || trace[1].Role != "tool" || trace[1].ToolName != "weather" {
+		t.Errorf("expected tool call, result and reply in the trace, got %+v", trace)
+	}
+}
+
+func TestGetChatCompletionWithToolsUnregistered(t *testing.T) {
+	svc := newTestService(t, newMockChat(t, false).URL)
+
+	result, trace, err := svc.GetChatCompletionWithTools(context.Background(), ChatRequest{
+		Messages: []Message{{Role: "user", Content: "weather?"}},
+		Tools:    []Tool{weatherTool},
+	}, nil, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Message.ToolCalls) != 1 || len(trace) != 1 {
+		t.Errorf("expected the tool call to be returned to the client, got %+v", trace)
+	}
+}
+
+func TestGetChatCompletionWithToolsLimit(t *testing.T) {
+	svc := newTestService(t, newMockChat(t, true).URL)
+	calls := 0
+	tools := ToolRegistry{"weather": func(context.Context, json.RawMessage) (string, error) {
+		calls++
+		return "sunny", nil
+	}}
+
+	_, _, err := svc.GetChatCompletionWithTools(context.Background(), ChatRequest{
+		Messages: []Message{{Role: "user", Content: "weather?"}},
+		Tools:    []Tool{weatherTool},
+	}, tools, 2)
+	if !errors.Is(err, ErrToolLoopLimit) {
+		t.Fatalf("expected ErrToolLoopLimit, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 tool calls, got %d", calls)
+	}
+}
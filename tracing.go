@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName identifies this service in traces unless OTEL_SERVICE_NAME
+// overrides it
+const serviceName = "homuncullm"
+
+// tracer creates the service's spans. It does nothing until setupTracing
+// installs an exporting tracer provider.
+var tracer = otel.Tracer("github.com/junkd0g/HomuncuLLM")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard
+// OTEL_* variables. Otherwise tracing stays a no-op. The returned func
+// flushes buffered spans and must be called before exiting.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	// Trace context is always propagated, so a caller's trace carries on to
+	// Ollama even when this service doesn't export its own spans
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return noop, nil
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/protobuf" {
+		slog.Warn("only the http/protobuf OTLP protocol is supported, using it", "protocol", protocol)
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName), attribute.String("service.version", version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a span for every request, continuing the trace of
+// an incoming traceparent header. Spans are named after the route so they
+// group well however many distinct paths it matches.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request.id", c.GetString(requestIDContextKey)),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if model := c.GetString(modelContextKey); model != "" {
+			span.SetAttributes(attribute.String("gen_ai.request.model", model))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// ollamaSpanKey is the context.Context key holding the span of the Ollama
+// call in progress
+type ollamaSpanKey struct{}
+
+// startOllamaSpan starts the child span of a call to Ollama's endpoint, which
+// lasts until its response has been read
+func startOllamaSpan(ctx context.Context, endpoint string, model string) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "ollama "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("ollama.endpoint", endpoint),
+			attribute.String("gen_ai.request.model", model),
+		),
+	)
+	return context.WithValue(ctx, ollamaSpanKey{}, span), span
+}
+
+// ollamaSpan returns the span of the Ollama call ctx belongs to. Calls made
+// without one get a span that records nothing, so the request's own span is
+// never marked with an error its handler may have tolerated.
+func ollamaSpan(ctx context.Context) trace.Span {
+	if span, ok := ctx.Value(ollamaSpanKey{}).(trace.Span); ok {
+		return span
+	}
+	return trace.SpanFromContext(context.Background())
+}
+
+// failOllamaSpan marks the span of the Ollama call ctx belongs to as failed
+func failOllamaSpan(ctx context.Context, err error) {
+	span := ollamaSpan(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
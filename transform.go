@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Transform rewrites a prompt before it is sent to Ollama, or a response
+// before it is returned to the client
+type Transform interface {
+	Apply(ctx context.Context, text string) (string, error)
+}
+
+// TransformFunc adapts an ordinary function to Transform
+type TransformFunc func(ctx context.Context, text string) (string, error)
+
+// Apply calls f
+func (f TransformFunc) Apply(ctx context.Context, text string) (string, error) {
+	return f(ctx, text)
+}
+
+// TransformFactory builds a transform from its entry in the config file,
+// which holds its name along with any settings it takes
+type TransformFactory func(spec json.RawMessage) (Transform, error)
+
+// transformFactories are the transforms the config file can name
+var transformFactories = map[string]TransformFactory{}
+
+// RegisterTransform makes a transform available to the config file under name
+func RegisterTransform(name string, factory TransformFactory) {
+	transformFactories[name] = factory
+}
+
+func init() {
+	RegisterTransform("prefix", newPrefixTransform)
+	RegisterTransform("suffix", newSuffixTransform)
+	RegisterTransform("redact", newRedactTransform)
+	RegisterTransform("stripThinking", newStripThinkingTransform)
+	RegisterTransform("template", newTemplateTransform)
+	RegisterTransform("trim", newTrimTransform)
+}
+
+// Pipeline runs transforms in order, each on the output of the one before
+type Pipeline []Transform
+
+// Apply runs text through every transform, stopping at the first that fails
+func (p Pipeline) Apply(ctx context.Context, text string) (string, error) {
+	for _, transform := range p {
+		var err error
+		if text, err = transform.Apply(ctx, text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// Transforms are the pipelines GetCompletion runs on the prompt before
+// generating and on the response after
+type Transforms struct {
+	Pre  Pipeline
+	Post Pipeline
+}
+
+// TransformsConfig lists the transforms to run, in order, as the config
+// file's transforms section. Each entry is an object naming a registered
+// transform, such as {"name": "redact", "pattern": "\\d{16}"}.
+type TransformsConfig struct {
+	Pre  []json.RawMessage `json:"pre"`
+	Post []json.RawMessage `json:"post"`
+}
+
+// build creates the pipelines the config lists
+func (cfg *TransformsConfig) build() (Transforms, error) {
+	if cfg == nil {
+		return Transforms{}, nil
+	}
+	pre, err := buildPipeline(cfg.Pre)
+	if err != nil {
+		return Transforms{}, fmt.Errorf("pre: %w", err)
+	}
+	post, err := buildPipeline(cfg.Post)
+	if err != nil {
+		return Transforms{}, fmt.Errorf("post: %w", err)
+	}
+	return Transforms{Pre: pre, Post: post}, nil
+}
+
+// buildPipeline creates each transform in specs with its registered factory
+func buildPipeline(specs []json.RawMessage) (Pipeline, error) {
+	pipeline := make(Pipeline, 0, len(specs))
+	for i, spec := range specs {
+		var named struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(spec, &named); err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i+1, err)
+		}
+		factory, ok := transformFactories[named.Name]
+		if !ok {
+			return nil, fmt.Errorf("transform %d: unknown transform %q", i+1, named.Name)
+		}
+		transform, err := factory(spec)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %w", i+1, named.Name, err)
+		}
+		pipeline = append(pipeline, transform)
+	}
+	return pipeline, nil
+}
+
+// decodeTransformSpec decodes a transform's settings into v, rejecting
+// unknown fields like the rest of the config file
+func decodeTransformSpec(spec json.RawMessage, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(spec))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// newPrefixTransform adds text before the input
+func newPrefixTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name string `json:"name"`
+		Text string `json:"text"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		return cfg.Text + text, nil
+	}), nil
+}
+
+// newSuffixTransform adds text after the input
+func newSuffixTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name string `json:"name"`
+		Text string `json:"text"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		return text + cfg.Text, nil
+	}), nil
+}
+
+// newRedactTransform replaces every match of pattern, with [REDACTED] unless
+// a replacement is given. The replacement may refer to groups as $1.
+func newRedactTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name        string  `json:"name"`
+		Pattern     string  `json:"pattern"`
+		Replacement *string `json:"replacement"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Pattern == "" {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	replacement := "[REDACTED]"
+	if cfg.Replacement != nil {
+		replacement = *cfg.Replacement
+	}
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		return re.ReplaceAllString(text, replacement), nil
+	}), nil
+}
+
+// newStripThinkingTransform removes reasoning blocks, using THINKING_TAGS'
+// defaults unless tags are given
+func newStripThinkingTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Tags) == 0 {
+		cfg.Tags = defaultThinkingTags
+	}
+	stripper := NewThinkingStripper(cfg.Tags)
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		response, _ := stripper.Strip(text)
+		return response, nil
+	}), nil
+}
+
+// newTemplateTransform renders a Go template with the input as {{.Text}}
+func newTemplateTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name     string `json:"name"`
+		Template string `json:"template"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("transform").Option("missingkey=error").Parse(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		var out strings.Builder
+		if err := tmpl.Execute(&out, struct{ Text string }{text}); err != nil {
+			return "", fmt.Errorf("failed to render transform template: %w", err)
+		}
+		return out.String(), nil
+	}), nil
+}
+
+// newTrimTransform removes leading and trailing whitespace
+func newTrimTransform(spec json.RawMessage) (Transform, error) {
+	var cfg struct {
+		Name string `json:"name"`
+	}
+	if err := decodeTransformSpec(spec, &cfg); err != nil {
+		return nil, err
+	}
+	return TransformFunc(func(_ context.Context, text string) (string, error) {
+		return strings.TrimSpace(text), nil
+	}), nil
+}
+
+// SetTransforms replaces the pipelines GetCompletion runs
+func (s *LLMService) SetTransforms(transforms Transforms) {
+	s.transforms.Store(&transforms)
+}
+
+// pipelines returns the transforms in effect
+func (s *LLMService) pipelines() Transforms {
+	if transforms := s.transforms.Load(); transforms != nil {
+		return *transforms
+	}
+	return Transforms{}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBuildPipeline(t *testing.T) {
+	var cfg TransformsConfig
+	err := json.Unmarshal([]byte(`{
+		"pre": [
+			{"name": "redact", "pattern": "\\d{4}-\\d{4}"},
+			{"name": "template", "template": "Question: {{.Text}}"}
+		],
+		"post": [{"name": "stripThinking"}, {"name": "suffix", "text": "!"}]
+	}`), &cfg)
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	transforms, err := cfg.build()
+	if err != nil {
+		t.Fatalf("build returned error: %v", err)
+	}
+
+	if got, _ := transforms.Pre.Apply(context.Background(), "card 1234-5678?"); got != "Question: card [REDACTED]?" {
+		t.Errorf("unexpected prompt %q", got)
+	}
+	if got, _ := transforms.Post.Apply(context.Background(), "<think>hmm</think>Hello"); got != "Hello!" {
+		t.Errorf("unexpected response %q", got)
+	}
+}
+
+func TestBuildPipelineErrors(t *testing.T) {
+	for _, spec := range []string{
+		`{"name": "shout"}`,
+		`{"name": "redact"}`,
+		`{"name": "prefix", "txt": "typo"}`,
+	} {
+		if _, err := buildPipeline([]json.RawMessage{json.RawMessage(spec)}); err == nil {
+			t.Errorf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestGetCompletionTransforms(t *testing.T) {
+	server, requests := newMockOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Response: "  Hello  ", Done: true})
+	})
+	svc := newTestService(t, server.URL)
+	svc.SetTransforms(Transforms{
+		Pre:  Pipeline{TransformFunc(func(_ context.Context, text string) (string, error) { return "[" + text + "]", nil })},
+		Post: Pipeline{TransformFunc(func(_ context.Context, text string) (string, error) { return text + "?", nil })},
+	})
+
+	resp, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("GetCompletion returned error: %v", err)
+	}
+	if sent := <-requests; sent.Prompt != "[hi]" {
+		t.Errorf("expected the transformed prompt to be sent, got %q", sent.Prompt)
+	}
+	if resp.Response != "  Hello  ?" {
+		t.Errorf("expected the transformed response, got %q", resp.Response)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// newOllamaTransport builds the transport used for Ollama calls, with a
+// timeout for each connection phase so an unreachable backend fails fast
+// while the overall request is only bound by its context deadline. Enough
+// idle connections are kept for concurrent requests to reuse them rather
+// than each dialing its own.
+func newOllamaTransport(cfg LLMConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	return transport
+}
+
+// deadlineBody is a response body read under the request's deadline. Closing
+// it releases the deadline, and a read cut off by the deadline reports
+// ErrTimeout instead of a bare I/O error.
+type deadlineBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.ctx.Err() != nil {
+		err = wrapRequestError(b.ctx.Err())
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// idleBody is a streamed response body that gives up once Ollama stops
+// sending, closing the stream and failing reads with ErrStreamStalled. The
+// wait for the first data isn't bounded, since loading the model and reading
+// a long prompt can take a while before anything is generated.
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// watchIdle wraps a streamed body so it stalls after timeout without data. A
+// timeout of zero or less leaves body unwatched.
+func watchIdle(body io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+	return &idleBody{ReadCloser: body, timeout: timeout}
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.stalled.Load() {
+		return n, ErrStreamStalled
+	}
+	if n > 0 {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.timeout, b.stall)
+		} else {
+			b.timer.Reset(b.timeout)
+		}
+	}
+	return n, err
+}
+
+// stall abandons the stream, unblocking the read waiting on it
+func (b *idleBody) stall() {
+	slog.Warn("ollama stream stalled, cancelling it", "idleTimeout", b.timeout.String())
+	b.stalled.Store(true)
+	b.ReadCloser.Close()
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingOllama starts a server answering every generation, counting the
+// connections opened to it
+func newCountingOllama(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	tb.Helper()
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Model: "llama2", Response: "ok", Done: true})
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	tb.Cleanup(server.Close)
+	return server, &conns
+}
+
+// newPooledService builds a service allowing concurrent requests, with the
+// given pool size per host
+func newPooledService(url string, concurrent int, idlePerHost int) *LLMService {
+	return NewLLMService(LLMConfig{
+		OllamaURLs:          []string{url},
+		DefaultModel:        "llama2",
+		Timeout:             5 * time.Second,
+		MaxConcurrent:       concurrent,
+		MaxQueueWait:        time.Minute,
+		MaxIdleConns:        idlePerHost,
+		MaxIdleConnsPerHost: idlePerHost,
+	})
+}
+
+func TestOllamaTransportReusesConnections(t *testing.T) {
+	server, conns := newCountingOllama(t)
+	const concurrent = 8
+	svc := newPooledService(server.URL, concurrent, concurrent)
+
+	for range 5 {
+		var wg sync.WaitGroup
+		for range concurrent {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if n := conns.Load(); n > concurrent {
+		t.Errorf("expected at most %d connections for %d requests, got %d", concurrent, 5*concurrent, n)
+	}
+}
+
+// BenchmarkConcurrentCompletions compares Go's default pool of 2 idle
+// connections per host with a pool sized for the concurrency, reporting the
+// connections dialed per request
+func BenchmarkConcurrentCompletions(b *testing.B) {
+	const concurrent = 32
+	for _, bench := range []struct {
+		name        string
+		idlePerHost int
+	}{
+		{"default", http.DefaultMaxIdleConnsPerHost},
+		{"tuned", defaultMaxIdleConnsPerHost},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			server, conns := newCountingOllama(b)
+			svc := newPooledService(server.URL, concurrent, bench.idlePerHost)
+			b.SetParallelism(concurrent)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, injected at build time with
+// -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// OllamaVersionResponse is the structure of Ollama's /api/version response
+type OllamaVersionResponse struct {
+	Version string `json:"version"`
+}
+
+// VersionResponse reports the versions of this service and of Ollama
+type VersionResponse struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	GoVersion     string `json:"goVersion"`
+	OllamaVersion string `json:"ollamaVersion,omitempty"`
+	OllamaError   string `json:"ollamaError,omitempty"`
+}
+
+// GetVersion returns the version of the Ollama server
+func (s *LLMService) GetVersion(ctx context.Context) (string, error) {
+	resp, err := s.get(ctx, "/api/version")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var versionResp OllamaVersionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&versionResp); err != nil {
+		return "", fmt.Errorf("failed to decode ollama version response: %w", err)
+	}
+
+	return versionResp.Version, nil
+}
+
+// versionHandler serves GET /api/version. The service's own version is
+// reported even when Ollama can't be reached.
+func versionHandler(llmService *LLMService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resp := VersionResponse{
+			Version:   version,
+			Commit:    commit,
+			GoVersion: runtime.Version(),
+		}
+
+		ollamaVersion, err := llmService.GetVersion(c.Request.Context())
+		if err != nil {
+			resp.OllamaError = err.Error()
+		}
+		resp.OllamaVersion = ollamaVersion
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmClientKey is the context.Context key holding the client of the warm
+// worker a generation runs on
+type warmClientKey struct{}
+
+// WarmPool keeps workers ready for latency-sensitive traffic. Each worker has
+// its own client holding a connection to Ollama open, and the pool keeps the
+// default model loaded, so a generation on a worker skips both setting up a
+// connection and loading the model. Generations that find every worker busy
+// run on demand over the shared client, as they would without a pool.
+type WarmPool struct {
+	idle chan *http.Client
+	size int
+
+	// hits and misses count generations that got a worker and those that
+	// ran on demand
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// mu guards the time spent getting a connection, warm and on demand,
+	// since latency was last logged
+	mu         sync.Mutex
+	warmConn   latencySum
+	onDemand   latencySum
+	lastLogged time.Time
+}
+
+// latencySum adds up durations to average them
+type latencySum struct {
+	total time.Duration
+	count int
+}
+
+func (l *latencySum) add(d time.Duration) {
+	l.total += d
+	l.count++
+}
+
+func (l latencySum) mean() time.Duration {
+	if l.count == 0 {
+		return 0
+	}
+	return l.total / time.Duration(l.count)
+}
+
+// NewWarmPool creates a pool of size workers, each with a transport built
+// from cfg that keeps a single connection per Ollama. It returns nil, turning
+// the pool off, when size is zero or less.
+func NewWarmPool(size int, cfg LLMConfig) *WarmPool {
+	if size <= 0 {
+		return nil
+	}
+	cfg.MaxIdleConns, cfg.MaxIdleConnsPerHost = len(cfg.OllamaURLs), 1
+	p := &WarmPool{idle: make(chan *http.Client, size), size: size, lastLogged: time.Now()}
+	for range size {
+		p.idle <- &http.Client{Transport: newOllamaTransport(cfg)}
+	}
+	return p
+}
+
+// use hands ctx a worker's client when one is idle, returning the context to
+// generate under and a func to call once the generation is over. A nil pool
+// or one with every worker busy leaves the generation to run on demand.
+func (p *WarmPool) use(ctx context.Context) (context.Context, func()) {
+	if p == nil {
+		return ctx, func() {}
+	}
+	select {
+	case client := <-p.idle:
+		p.hits.Add(1)
+		ctx = p.traceConn(context.WithValue(ctx, warmClientKey{}, client), true)
+		return ctx, func() { p.idle <- client }
+	default:
+		p.misses.Add(1)
+		return p.traceConn(ctx, false), func() {}
+	}
+}
+
+// traceConn records how long an Ollama call on ctx waits for a connection,
+// warm or on demand
+func (p *WarmPool) traceConn(ctx context.Context, warm bool) context.Context {
+	var start time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { start = time.Now() },
+		GotConn: func(httptrace.GotConnInfo) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if warm {
+				p.warmConn.add(time.Since(start))
+			} else {
+				p.onDemand.add(time.Since(start))
+			}
+		},
+	})
+}
+
+// Run keeps the workers warm until ctx is done: every interval each idle
+// worker touches Ollama so its connection isn't closed as idle, and the
+// default model is loaded again for keepAlive. Busy workers are kept warm
+// by their generation. How much faster warm connections were is logged at
+// each round that saw traffic.
+func (p *WarmPool) Run(ctx context.Context, llm *LLMService, interval time.Duration, keepAlive json.RawMessage) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.warm(ctx, llm, keepAlive)
+		p.logLatency()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// warm refreshes the idle workers' connections and the default model. Workers
+// are pinged one at a time, each back in the pool before the next is taken,
+// so a generation arriving meanwhile still finds one idle.
+func (p *WarmPool) warm(ctx context.Context, llm *LLMService, keepAlive json.RawMessage) {
+	if err := llm.PreloadModel(ctx, "", keepAlive); err != nil && ctx.Err() == nil {
+		slog.Warn("warm pool failed to keep the default model loaded", "model", llm.ResolveModel(""), "error", err)
+	}
+
+	for range p.size {
+		var client *http.Client
+		select {
+		case client = <-p.idle:
+		default:
+			return
+		}
+		err := llm.Ping(context.WithValue(ctx, warmClientKey{}, client))
+		p.idle <- client
+		if err != nil && ctx.Err() == nil {
+			slog.Debug("warm pool worker failed to reach ollama", "error", err)
+		}
+	}
+}
+
+// logLatency logs the worker hits and misses and the average wait for a
+// connection, warm and on demand, since the last time
+func (p *WarmPool) logLatency() {
+	hits, misses := p.hits.Swap(0), p.misses.Swap(0)
+	p.mu.Lock()
+	warm, onDemand, since := p.warmConn, p.onDemand, time.Since(p.lastLogged)
+	p.warmConn, p.onDemand, p.lastLogged = latencySum{}, latencySum{}, time.Now()
+	p.mu.Unlock()
+	if hits+misses == 0 {
+		return
+	}
+	attrs := []any{
+		"period", since.Round(time.Second).String(),
+		"warm", hits,
+		"on_demand", misses,
+		"warm_conn_wait", warm.mean().String(),
+		"on_demand_conn_wait", onDemand.mean().String(),
+	}
+	if warm.count > 0 && onDemand.count > 0 {
+		attrs = append(attrs, "saved_per_request", (onDemand.mean() - warm.mean()).String())
+	}
+	slog.Info("warm pool latency", attrs...)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWarmPoolFallsBackWhenExhausted(t *testing.T) {
+	pool := NewWarmPool(1, LLMConfig{OllamaURLs: []string{"http://localhost"}})
+
+	ctx, done := pool.use(context.Background())
+	if ctx.Value(warmClientKey{}) == nil {
+		t.Fatal("expected the first generation to get a worker")
+	}
+	onDemand, doneOnDemand := pool.use(context.Background())
+	if onDemand.Value(warmClientKey{}) != nil {
+		t.Error("expected the second generation to run on demand")
+	}
+	doneOnDemand()
+	done()
+
+	if ctx, done := pool.use(context.Background()); ctx.Value(warmClientKey{}) == nil {
+		t.Error("expected the worker back in the pool once released")
+	} else {
+		done()
+	}
+	if pool.hits.Load() != 2 || pool.misses.Load() != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %d and %d", pool.hits.Load(), pool.misses.Load())
+	}
+
+	var disabled *WarmPool
+	if ctx, done := disabled.use(context.Background()); ctx.Value(warmClientKey{}) != nil {
+		t.Error("expected a nil pool to hand out no worker")
+	} else {
+		done()
+	}
+}
+
+func TestWarmPoolReusesWorkerConnections(t *testing.T) {
+	server, conns := newCountingOllama(t)
+	svc := NewLLMService(LLMConfig{
+		OllamaURLs:    []string{server.URL},
+		DefaultModel:  "llama2",
+		Timeout:       5 * time.Second,
+		MaxConcurrent: 1,
+		MaxQueueWait:  time.Second,
+		WarmPoolSize:  1,
+	})
+
+	// The preload goes over the shared client, the ping opens the worker's
+	// connection
+	svc.warmPool.warm(context.Background(), svc, nil)
+	if n := conns.Load(); n != 2 {
+		t.Fatalf("expected 2 connections after warming, got %d", n)
+	}
+	for range 5 {
+		if _, err := svc.GetCompletion(context.Background(), PromptRequest{Prompt: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("expected generations to reuse the warm connection, got %d connections", n)
+	}
+	if svc.warmPool.warmConn.count != 5 || svc.warmPool.onDemand.count != 0 {
+		t.Errorf("expected 5 warm connection waits, got %d warm and %d on demand", svc.warmPool.warmConn.count, svc.warmPool.onDemand.count)
+	}
+}
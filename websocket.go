@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPongWait is how long the connection may go without a pong or message
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait so pongs arrive in time
+	wsPingInterval = wsPongWait * 9 / 10
+	// wsWriteWait bounds every write to the client
+	wsWriteWait = 10 * time.Second
+)
+
+// WSClientMessage is sent by the client. Type "chat" starts a generation and
+// "cancel" aborts the one in progress without closing the socket.
+type WSClientMessage struct {
+	Type string `json:"type"`
+	ChatRequest
+}
+
+// WSServerMessage is sent to the client. Type is one of "queued", "token",
+// "done", "cancelled" or "error".
+type WSServerMessage struct {
+	Type          string `json:"type"`
+	Position      int    `json:"position,omitempty"`
+	EstimatedWait string `json:"estimatedWait,omitempty"`
+	Content       string `json:"content,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Time          string `json:"time,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Code is the error's code, as in the HTTP API's error responses
+	Code ErrorCode `json:"code,omitempty"`
+	// Stalled is set on done when the model stopped generating for
+	// STREAM_IDLE_TIMEOUT
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+// wsSession is one WebSocket connection running at most one generation at a time
+type wsSession struct {
+	conn           *websocket.Conn
+	llm            *LLMService
+	validator      *ModelValidator
+	maxPromptBytes int
+
+	// writeMu serializes writes, the connection allows a single writer
+	writeMu sync.Mutex
+
+	// mu guards cancel, set while a generation runs
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	generating sync.WaitGroup
+}
+
+// wsHandler serves GET /api/ws, a bidirectional streaming chat
+func wsHandler(llmService *LLMService, validator *ModelValidator, maxPromptBytes int, origins originPolicy) gin.HandlerFunc {
+	// Browsers don't apply CORS to WebSockets, so check the origin here
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || origins.allows(origin)
+		},
+	}
+
+	return func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already written the error response
+			return
+		}
+
+		s := &wsSession{
+			conn:           conn,
+			llm:            llmService,
+			validator:      validator,
+			maxPromptBytes: maxPromptBytes,
+		}
+		s.run(c.Request.Context())
+	}
+}
+
+// run reads client messages until the connection closes, then cancels any
+// generation in progress and waits for it before closing the socket
+func (s *wsSession) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.generating.Wait()
+		s.conn.Close()
+	}()
+
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go s.keepAlive(ctx)
+
+	for {
+		var msg WSClientMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.WarnContext(ctx, "websocket closed unexpectedly", "error", err)
+			}
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch msg.Type {
+		case "chat":
+			s.startChat(ctx, msg.ChatRequest)
+		case "cancel":
+			s.cancelChat()
+		default:
+			s.write(WSServerMessage{Type: "error", Code: CodeBadRequest, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+	}
+}
+
+// keepAlive pings the client until ctx is done
+func (s *wsSession) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// startChat validates req and streams its generation in the background
+func (s *wsSession) startChat(ctx context.Context, req ChatRequest) {
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		s.write(WSServerMessage{Type: "error", Code: CodeBadRequest, Error: err.Error()})
+		return
+	}
+	if s.maxPromptBytes > 0 && chatContentSize(req.Messages) > s.maxPromptBytes {
+		s.write(WSServerMessage{Type: "error", Code: CodePayloadTooLarge, Error: "prompt too large"})
+		return
+	}
+	if err := s.validator.Validate(ctx, req.Model); err != nil {
+		s.write(WSServerMessage{Type: "error", Code: errorCode(err), Error: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.write(WSServerMessage{Type: "error", Code: CodeBadRequest, Error: "a generation is already in progress, cancel it first"})
+		return
+	}
+	genCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+
+	s.generating.Add(1)
+	go func() {
+		defer s.generating.Done()
+		defer s.finishChat()
+
+		queuedCtx := WithQueueObserver(genCtx, func(position int, estimatedWait time.Duration) {
+			s.write(WSServerMessage{Type: "queued", Position: position, EstimatedWait: estimatedWaitString(estimatedWait)})
+		})
+
+		startTime := time.Now()
+		err := s.llm.GetChatCompletionStream(queuedCtx, req, func(token string) error {
+			return s.write(WSServerMessage{Type: "token", Content: token})
+		})
+		switch {
+		case errors.Is(err, context.Canceled):
+			s.write(WSServerMessage{Type: "cancelled"})
+		case errors.Is(err, ErrStreamStalled):
+			s.write(WSServerMessage{Type: "done", Model: req.Model, Time: time.Since(startTime).String(), Stalled: true})
+		case err != nil:
+			s.write(WSServerMessage{Type: "error", Code: errorCode(err), Error: err.Error()})
+		default:
+			s.write(WSServerMessage{Type: "done", Model: req.Model, Time: time.Since(startTime).String()})
+		}
+	}()
+}
+
+// cancelChat aborts the generation in progress, if any
+func (s *wsSession) cancelChat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// finishChat clears the finished generation so a new one can start
+func (s *wsSession) finishChat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel()
+	s.cancel = nil
+}
+
+// write sends msg to the client
+func (s *wsSession) write(msg WSServerMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(msg)
+}